@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// ANSI stripping modes for --strip-ansi.
+const (
+	stripAuto   = "auto"   // strip for files and the HTTP log stream, keep for a terminal
+	stripAlways = "always" // strip everywhere, including the terminal
+	stripNever  = "never"  // pass escape sequences through everywhere
+)
+
+func validStripMode(mode string) error {
+	switch mode {
+	case stripAuto, stripAlways, stripNever:
+		return nil
+	}
+	return fmt.Errorf("invalid --strip-ansi %q (want auto, always or never)", mode)
+}
+
+// ansiStripper removes ANSI escape sequences from a byte stream. It keeps
+// its state between calls, so a sequence split across writes is still
+// removed. It handles CSI (ESC [ ... final), OSC (ESC ] ... BEL or ESC \)
+// and two-byte ESC sequences.
+type ansiStripper struct {
+	state int
+}
+
+const (
+	ansiText = iota
+	ansiEscape
+	ansiCSI
+	ansiOSC
+	ansiOSCEscape
+)
+
+func (s *ansiStripper) strip(p []byte) []byte {
+	out := make([]byte, 0, len(p))
+	for _, b := range p {
+		switch s.state {
+		case ansiText:
+			if b == 0x1b {
+				s.state = ansiEscape
+			} else {
+				out = append(out, b)
+			}
+		case ansiEscape:
+			switch b {
+			case '[':
+				s.state = ansiCSI
+			case ']':
+				s.state = ansiOSC
+			default:
+				s.state = ansiText
+			}
+		case ansiCSI:
+			// Parameter and intermediate bytes run until a final byte.
+			if b >= 0x40 && b <= 0x7e {
+				s.state = ansiText
+			}
+		case ansiOSC:
+			if b == 0x07 {
+				s.state = ansiText
+			} else if b == 0x1b {
+				s.state = ansiOSCEscape
+			}
+		case ansiOSCEscape:
+			if b == '\\' {
+				s.state = ansiText
+			} else {
+				s.state = ansiOSC
+			}
+		}
+	}
+	return out
+}
+
+func stripANSI(s string) string {
+	var st ansiStripper
+	return string(st.strip([]byte(s)))
+}
+
+// ansiWriter strips escape sequences from everything written through it.
+type ansiWriter struct {
+	dst io.Writer
+	s   ansiStripper
+}
+
+func (a *ansiWriter) Write(p []byte) (int, error) {
+	if _, err := a.dst.Write(a.s.strip(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// stripFor reports whether output written to f should lose its escape
+// sequences.
+func (w *Watcher) stripFor(f *os.File) bool {
+	switch w.stripANSI {
+	case stripAlways:
+		return true
+	case stripNever:
+		return false
+	}
+	return !isTerminal(f)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// buildLockPoll is how often a held --build-lock is tried again.
+const buildLockPoll = 100 * time.Millisecond
+
+// errBuildLocked means another builder held --build-lock for all of
+// --build-lock-timeout, so the build didn't run.
+var errBuildLocked = errors.New("--build-lock held by another builder")
+
+// acquireBuildLock takes --build-lock, waiting up to --build-lock-timeout
+// for another watcher or a manual build holding it. The lock is advisory and
+// belongs to the open file, so the OS drops it if the holder crashes. The
+// returned func releases it.
+func (w *Watcher) acquireBuildLock() (func(), error) {
+	f, err := os.OpenFile(w.buildLock, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening --build-lock: %w", err)
+	}
+	deadline := time.Now().Add(w.buildLockTimeout)
+	for waited := false; ; waited = true {
+		ok, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("locking %s: %w", w.buildLock, err)
+		}
+		if ok {
+			if waited {
+				log.Printf("Got build lock %s", w.buildLock)
+			}
+			return func() { f.Close() }, nil
+		}
+		if !waited {
+			log.Printf("Waiting for build lock %s...", w.buildLock)
+		}
+		if !time.Now().Before(deadline) || !w.sleep(buildLockPoll) {
+			f.Close()
+			return nil, fmt.Errorf("%w for %s (%s)", errBuildLocked, w.buildLockTimeout, w.buildLock)
+		}
+	}
+}
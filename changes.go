@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"sort"
+	"time"
+)
+
+// fileState is the metadata recorded for every watched file in a scan, and
+// for directories with --hash-dirs.
+type fileState struct {
+	size    int64
+	modTime time.Time
+	mode    fs.FileMode // type and permission bits, only with --hash-mode
+	sum     string      // content hash, only with --hash-content
+}
+
+// equal compares content rather than mtime when either state has a content
+// hash.
+func (f fileState) equal(o fileState) bool {
+	if f.sum != "" || o.sum != "" {
+		return f.size == o.size && f.sum == o.sum && f.mode == o.mode
+	}
+	return f.size == o.size && f.modTime.Equal(o.modTime) && f.mode == o.mode
+}
+
+// changeSet lists the files that differ between two scans, each slice sorted.
+type changeSet struct {
+	added    []string
+	modified []string
+	deleted  []string
+}
+
+func diffFiles(prev, cur map[string]fileState) changeSet {
+	var c changeSet
+	for path, st := range cur {
+		old, ok := prev[path]
+		if !ok {
+			c.added = append(c.added, path)
+		} else if !old.equal(st) {
+			c.modified = append(c.modified, path)
+		}
+	}
+	for path := range prev {
+		if _, ok := cur[path]; !ok {
+			c.deleted = append(c.deleted, path)
+		}
+	}
+	sort.Strings(c.added)
+	sort.Strings(c.modified)
+	sort.Strings(c.deleted)
+	return c
+}
+
+func (c changeSet) count() int {
+	return len(c.added) + len(c.modified) + len(c.deleted)
+}
+
+func (c changeSet) summary() string {
+	noun := "files"
+	if c.count() == 1 {
+		noun = "file"
+	}
+	return fmt.Sprintf("%d %s changed (%d modified, %d added, %d deleted)",
+		c.count(), noun, len(c.modified), len(c.added), len(c.deleted))
+}
+
+// logChanges lists every changed file when verbose logging is enabled.
+func (w *Watcher) logChanges(c changeSet) {
+	if !w.verbose {
+		return
+	}
+	for _, p := range c.modified {
+		w.debugf("  modified: %s", p)
+	}
+	for _, p := range c.added {
+		w.debugf("  added:    %s", p)
+	}
+	for _, p := range c.deleted {
+		w.debugf("  deleted:  %s", p)
+	}
+}
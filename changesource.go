@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// sourceEvent is one line from --change-source-cmd.
+type sourceEvent struct {
+	Reason string   `json:"reason"`
+	Files  []string `json:"files"`
+}
+
+// parseSourceEvent reads a --change-source-cmd line: either plain text, used
+// as the reason, or a JSON object {"reason": "...", "files": ["...", ...]}.
+func parseSourceEvent(line string) sourceEvent {
+	var ev sourceEvent
+	if strings.HasPrefix(line, "{") && json.Unmarshal([]byte(line), &ev) == nil {
+		if ev.Reason == "" {
+			ev.Reason = "change source"
+		}
+		return ev
+	}
+	return sourceEvent{Reason: line}
+}
+
+const (
+	sourceBackoffMin = time.Second
+	sourceBackoffMax = time.Minute
+	// sourceStopGrace is how long --change-source-cmd gets to exit after
+	// SIGTERM, and then after SIGKILL, when the watcher stops.
+	sourceStopGrace = 5 * time.Second
+)
+
+// changeSourceLoop keeps --change-source-cmd running until quit is closed,
+// restarting it with a doubling backoff whenever it exits. A run that lasted
+// longer than the longest backoff resets it.
+func (w *Watcher) changeSourceLoop(quit <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	backoff := sourceBackoffMin
+	for {
+		started := time.Now()
+		err := w.runChangeSource(quit)
+		select {
+		case <-quit:
+			return
+		default:
+		}
+		if time.Since(started) > sourceBackoffMax {
+			backoff = sourceBackoffMin
+		}
+		if err == nil {
+			err = fmt.Errorf("exited")
+		}
+		log.Printf("--change-source-cmd %v; restarting it in %s", err, backoff)
+		select {
+		case <-quit:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, sourceBackoffMax)
+	}
+}
+
+// runChangeSource runs --change-source-cmd once, queueing a rebuild for every
+// line it prints, until it exits or quit is closed. On quit its output pipe
+// is closed and its process group goes through SIGTERM and then SIGKILL, so
+// a command that ignores SIGTERM or keeps stdout open can't hold up the exit.
+func (w *Watcher) runChangeSource(quit <-chan struct{}) error {
+	cmd := exec.Command("/bin/sh", "-c", w.changeSourceCmd)
+	cmd.Stderr = w.outputWriter("source", os.Stderr)
+	cmd.WaitDelay = 2 * time.Second
+	setProcessGroup(cmd)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	eof := make(chan struct{})
+	go func() {
+		defer close(eof)
+		sc := bufio.NewScanner(out)
+		for sc.Scan() {
+			if line := strings.TrimSpace(sc.Text()); line != "" {
+				w.queueSourceEvent(parseSourceEvent(line))
+			}
+		}
+	}()
+	select {
+	case <-eof:
+		return cmd.Wait()
+	case <-quit:
+	}
+
+	out.Close()
+	<-eof
+	waited := make(chan error, 1)
+	go func() { waited <- cmd.Wait() }()
+	exited := func(d time.Duration) bool {
+		select {
+		case <-waited:
+			return true
+		case <-time.After(d):
+			return false
+		}
+	}
+	steps := []signalStep{{sig: syscall.SIGTERM, wait: sourceStopGrace}}
+	if escalate("change source", steps, func(sig syscall.Signal) { _ = signalGroup(cmd, sig) }, exited) {
+		// The shell exited, but children that ignored SIGTERM are still in
+		// its group
+		_ = signalGroup(cmd, syscall.SIGKILL)
+	} else if !exited(sourceStopGrace) {
+		log.Println("--change-source-cmd didn't exit after SIGKILL; leaving it")
+	}
+	return nil
+}
+
+// queueSourceEvent records ev and queues a trigger for it, unless one is
+// already queued: events arriving while a build runs are folded into one
+// rebuild.
+func (w *Watcher) queueSourceEvent(ev sourceEvent) {
+	w.sourceMu.Lock()
+	defer w.sourceMu.Unlock()
+	w.sourceEvents = append(w.sourceEvents, ev)
+	if w.sourceQueued {
+		return
+	}
+	select {
+	case w.triggers <- triggerSource:
+		w.sourceQueued = true
+	default:
+		// The run loop picks it up on its next wait
+	}
+}
+
+// sourceStranded reports whether --change-source-cmd events are waiting
+// without a queued trigger, because the queue was full when they came in.
+func (w *Watcher) sourceStranded() bool {
+	w.sourceMu.Lock()
+	defer w.sourceMu.Unlock()
+	return len(w.sourceEvents) > 0 && !w.sourceQueued
+}
+
+// rebuildFromSource rebuilds for the queued --change-source-cmd events, or
+// records them while paused.
+func (w *Watcher) rebuildFromSource() {
+	w.sourceMu.Lock()
+	events := w.sourceEvents
+	w.sourceEvents, w.sourceQueued = nil, false
+	w.sourceMu.Unlock()
+	if len(events) == 0 {
+		return
+	}
+
+	seen := make(map[string]bool)
+	var reasons []string
+	var changes changeSet
+	for _, ev := range events {
+		if !seen[ev.Reason] {
+			seen[ev.Reason] = true
+			reasons = append(reasons, ev.Reason)
+		}
+		changes.modified = append(changes.modified, ev.Files...)
+	}
+	reason := strings.Join(reasons, "; ")
+	if len(reasons) > 3 {
+		reason = fmt.Sprintf("%s and %d more", strings.Join(reasons[:3], "; "), len(reasons)-3)
+	}
+
+	if w.paused.Load() {
+		log.Printf("Paused: ignoring change source: %s", reason)
+		w.changedWhilePaused = true
+		return
+	}
+	log.Printf("Change source: %s; rebuilding...", reason)
+	w.rebuild("source", changes)
+}
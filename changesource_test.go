@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRunChangeSourceIgnoringSIGTERM(t *testing.T) {
+	w := NewWatcher([]string{t.TempDir()}, time.Second, "", "", "", "", nil, nil)
+	// Holds stdout open through a child, too
+	w.changeSourceCmd = "trap '' TERM; echo started; sleep 60"
+
+	quit := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- w.runChangeSource(quit) }()
+	time.Sleep(200 * time.Millisecond)
+	close(quit)
+	select {
+	case <-done:
+	case <-time.After(sourceStopGrace + 3*time.Second):
+		t.Fatal("runChangeSource still running after SIGTERM and SIGKILL")
+	}
+}
+
+func TestSourceEventWithFullQueue(t *testing.T) {
+	w := NewWatcher([]string{t.TempDir()}, time.Second, "", "", "", "", nil, nil)
+	for range cap(w.triggers) {
+		w.triggers <- triggerRestart
+	}
+	w.queueSourceEvent(sourceEvent{Reason: "regenerated"})
+	if !w.sourceStranded() {
+		t.Fatal("event with a full queue isn't left for the run loop")
+	}
+	w.paused.Store(true)
+	w.wait()
+	if w.sourceStranded() || !w.changedWhilePaused {
+		t.Error("wait didn't handle the stranded event")
+	}
+}
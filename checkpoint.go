@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCheckpoints bounds how many named checkpoints the watcher keeps; a new
+// one past it replaces the oldest.
+const maxCheckpoints = 32
+
+var checkpointName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// checkpoint is the watched tree as of POST /checkpoint/{name}.
+type checkpoint struct {
+	taken time.Time
+	files map[string]fileState
+}
+
+// checkpoints holds the latest scan and the named snapshots taken of it, for
+// the HTTP handlers: the main loop replaces the scan, never changes it.
+type checkpoints struct {
+	mu      sync.Mutex
+	current map[string]fileState
+	byName  map[string]checkpoint
+}
+
+func (c *checkpoints) setCurrent(files map[string]fileState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current = files
+}
+
+// take snapshots the latest scan as name, returning its file count.
+func (c *checkpoints) take(name string) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.current == nil {
+		return 0, false
+	}
+	if _, ok := c.byName[name]; !ok && len(c.byName) >= maxCheckpoints {
+		oldest := ""
+		for n, cp := range c.byName {
+			if oldest == "" || cp.taken.Before(c.byName[oldest].taken) {
+				oldest = n
+			}
+		}
+		delete(c.byName, oldest)
+	}
+	if c.byName == nil {
+		c.byName = make(map[string]checkpoint)
+	}
+	c.byName[name] = checkpoint{taken: time.Now(), files: c.current}
+	return len(c.current), true
+}
+
+// diff returns what changed between checkpoint name and the latest scan.
+func (c *checkpoints) diff(name string) (checkpoint, changeSet, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cp, ok := c.byName[name]
+	if !ok {
+		return checkpoint{}, changeSet{}, false
+	}
+	return cp, diffFiles(cp.files, c.current), true
+}
+
+// persisted and restore convert to and from the state file's form.
+func (c *checkpoints) persisted() map[string]persistedCheckpoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.byName) == 0 {
+		return nil
+	}
+	out := make(map[string]persistedCheckpoint, len(c.byName))
+	for name, cp := range c.byName {
+		files := make(map[string]persistedState, len(cp.files))
+		for path, f := range cp.files {
+			files[path] = persistedState{Size: f.size, ModTime: f.modTime, Mode: uint32(f.mode), Sum: f.sum}
+		}
+		out[name] = persistedCheckpoint{Taken: cp.taken, Files: files}
+	}
+	return out
+}
+
+func (c *checkpoints) restore(saved map[string]persistedCheckpoint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.byName = make(map[string]checkpoint, len(saved))
+	for name, cp := range saved {
+		files := make(map[string]fileState, len(cp.Files))
+		for path, f := range cp.Files {
+			files[path] = fileState{size: f.Size, modTime: f.ModTime, mode: fs.FileMode(f.Mode), sum: f.Sum}
+		}
+		c.byName[name] = checkpoint{taken: cp.Taken, files: files}
+	}
+}
+
+type persistedCheckpoint struct {
+	Taken time.Time                 `json:"taken"`
+	Files map[string]persistedState `json:"files"`
+}
+
+// checkpointDiff is the GET /diff/{name} response.
+type checkpointDiff struct {
+	Checkpoint string    `json:"checkpoint"`
+	Taken      time.Time `json:"taken"`
+	Added      []string  `json:"added"`
+	Modified   []string  `json:"modified"`
+	Deleted    []string  `json:"deleted"`
+}
+
+func (w *Watcher) handleCheckpoint(rw http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !checkpointName.MatchString(name) {
+		http.Error(rw, "invalid checkpoint name, want letters, digits, '.', '_' or '-'", http.StatusBadRequest)
+		return
+	}
+	n, ok := w.checkpoints.take(name)
+	if !ok {
+		http.Error(rw, "no scan of the tree yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.debugf("Checkpoint %s taken of %d files", name, n)
+	rw.WriteHeader(http.StatusCreated)
+	writeJSON(rw, map[string]any{"checkpoint": name, "files": n})
+}
+
+func (w *Watcher) handleDiff(rw http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	cp, c, ok := w.checkpoints.diff(name)
+	if !ok {
+		http.Error(rw, "no checkpoint named "+name, http.StatusNotFound)
+		return
+	}
+	writeJSON(rw, checkpointDiff{
+		Checkpoint: name,
+		Taken:      cp.taken,
+		Added:      nonNil(c.added),
+		Modified:   nonNil(c.modified),
+		Deleted:    nonNil(c.deleted),
+	})
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// runCheckpoint implements `poly-watcher checkpoint NAME` and, with diff
+// set, `poly-watcher diff NAME` against the watcher's --http server.
+func runCheckpoint(args []string, diff bool) error {
+	cmd, usage := "checkpoint", "Snapshots the tree a watcher started with --http sees as NAME, for a later `poly-watcher diff NAME`."
+	if diff {
+		cmd, usage = "diff", "Lists the files changed since `poly-watcher checkpoint NAME` in a watcher started with --http."
+	}
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	addr := fs.String("http", "", "Address of the watcher's --http server (default from POLY_HTTP or poly.yaml)")
+	token := fs.String("http-auth-token", "", "Bearer token of a watcher started with --http-auth-token")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: poly-watcher %s [--http addr] NAME\n", cmd)
+		fmt.Fprintln(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("want exactly one checkpoint name")
+	}
+	name := fs.Arg(0)
+
+	base := watcherAddr(*addr)
+	if base == "" {
+		return fmt.Errorf("no watcher address; pass --http with the address the watcher's --http server listens on")
+	}
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	method, path := http.MethodPost, "/checkpoint/"
+	if diff {
+		method, path = http.MethodGet, "/diff/"
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(base, "/")+path+url.PathEscape(name), nil)
+	if err != nil {
+		return err
+	}
+	if *token != "" {
+		req.Header.Set("Authorization", "Bearer "+*token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		var msg [512]byte
+		n, _ := resp.Body.Read(msg[:])
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg[:n])))
+	}
+
+	if !diff {
+		var taken struct{ Files int }
+		if err := json.NewDecoder(resp.Body).Decode(&taken); err != nil {
+			return fmt.Errorf("bad checkpoint response: %v", err)
+		}
+		fmt.Printf("Checkpoint %s taken of %d files\n", name, taken.Files)
+		return nil
+	}
+	var d checkpointDiff
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return fmt.Errorf("bad diff response: %v", err)
+	}
+	if len(d.Added)+len(d.Modified)+len(d.Deleted) == 0 {
+		fmt.Printf("No changes since checkpoint %s (%s)\n", name, d.Taken.Local().Format(time.DateTime))
+		return nil
+	}
+	for _, p := range d.Modified {
+		fmt.Println("M", p)
+	}
+	for _, p := range d.Added {
+		fmt.Println("A", p)
+	}
+	for _, p := range d.Deleted {
+		fmt.Println("D", p)
+	}
+	return nil
+}
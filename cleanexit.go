@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// ErrUncleanExit means the app left processes behind in its process group
+// when it was stopped, with --verify-clean-exit fail. (exit 7)
+var ErrUncleanExit = errors.New("app left processes behind")
+
+// cleanExitGrace is how long children get to follow the app out, e.g. when
+// they got the same stop signal, before they count as left behind.
+const cleanExitGrace = 500 * time.Millisecond
+
+// groupMember is a process found in an app's process group.
+type groupMember struct {
+	pid     int
+	command string
+}
+
+// verifyCleanExit checks, for --verify-clean-exit, that procs, which have
+// just exited on a stop signal, left nothing running in their process
+// groups. Anything left is logged with its pid and command line before the
+// caller kills the groups. In fail mode the error is also kept for Run to
+// stop on; the caller holds processMu.
+func (w *Watcher) verifyCleanExit(procs []*appProcess) error {
+	if w.cleanExit == "" {
+		return nil
+	}
+	var leaks []string
+	for _, p := range procs {
+		if !p.group {
+			continue
+		}
+		members, left, err := leftBehind(p.cmd.Process.Pid)
+		if !left {
+			continue
+		}
+
+		log.Printf("Warning: %s left processes behind in its process group (--verify-clean-exit):", appLabel(p.name))
+		if err != nil {
+			log.Printf("  (couldn't list them: %v)", err)
+		}
+		pids := make([]string, len(members))
+		for i, m := range members {
+			log.Printf("  pid %d: %s", m.pid, m.command)
+			pids[i] = fmt.Sprint(m.pid)
+		}
+		if len(pids) == 0 {
+			leaks = append(leaks, p.name)
+		} else {
+			leaks = append(leaks, fmt.Sprintf("%s (pid %s)", p.name, strings.Join(pids, ", ")))
+		}
+	}
+	if len(leaks) == 0 || w.cleanExit != "fail" {
+		return nil
+	}
+	err := fmt.Errorf("%w: %s", ErrUncleanExit, strings.Join(leaks, "; "))
+	w.uncleanExit = err
+	return err
+}
+
+// leftBehind waits up to cleanExitGrace for process group pgid to empty and
+// reports whether it didn't, with what is still in it. err says why the
+// members couldn't be listed, in which case any process in the group counts.
+func leftBehind(pgid int) ([]groupMember, bool, error) {
+	deadline := time.Now().Add(cleanExitGrace)
+	for {
+		if !groupAlive(pgid) {
+			return nil, false, nil
+		}
+		members, err := groupMembers(pgid)
+		if err == nil && len(members) == 0 {
+			return nil, false, nil
+		}
+		if time.Now().After(deadline) {
+			return members, true, err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func (w *Watcher) uncleanExitErr() error {
+	w.processMu.Lock()
+	defer w.processMu.Unlock()
+	return w.uncleanExit
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"time"
+)
+
+// absorbBuildWrites rescans after a successful build and, if the build
+// itself only touched generated files, moves the baseline past those writes
+// so they don't trigger another build (e.g. a build that runs go generate).
+// A file counts as generated if it matches --generated-paths or, with
+// --absorb-build-writes, was modified while the build ran. Any other change
+// is left for the run loop to pick up as usual.
+func (w *Watcher) absorbBuildWrites(buildStart, buildEnd time.Time) {
+	if len(w.generatedPaths) == 0 && !w.absorbWrites {
+		return
+	}
+	scan, err := w.hashDir()
+	if err != nil || scan.hash == w.prevHash {
+		return
+	}
+	changes := diffFiles(w.prevFiles, scan.files)
+	for _, name := range append(append(changes.added, changes.modified...), changes.deleted...) {
+		if w.generatedPath(name) {
+			continue
+		}
+		if st, ok := scan.files[name]; ok && w.absorbWrites && !st.modTime.Before(buildStart) && !st.modTime.After(buildEnd) {
+			continue
+		}
+		return
+	}
+
+	log.Printf("Build wrote %d generated files; not rebuilding for them", changes.count())
+	w.logChanges(changes)
+	w.prevHash = scan.hash
+	w.prevFiles = scan.files
+}
+
+// generatedPath reports whether name (as recorded in a scan) matches a
+// --generated-paths rule.
+func (w *Watcher) generatedPath(name string) bool {
+	rel := w.relativeToRoot(name)
+	for _, rule := range w.generatedPaths {
+		if matchRule(rule, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// relativeToRoot turns a recorded name back into the path relative to the
+// root it was found under, as include and exclude rules see it.
+func (w *Watcher) relativeToRoot(name string) string {
+	for _, root := range w.roots {
+		if rel, err := filepath.Rel(root, name); err == nil && filepath.IsLocal(rel) {
+			return rel
+		}
+	}
+	return name
+}
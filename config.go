@@ -0,0 +1,904 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// minInterval is the fastest the tree is polled. Every poll walks the whole
+// tree, so anything tighter just burns a core.
+const minInterval = 50 * time.Millisecond
+
+// config holds the command-line settings.
+type config struct {
+	roots          listFlag
+	buildCmd       string
+	checkCmd       string
+	buildRetries   int
+	retryBackoff   time.Duration
+	heartbeat      time.Duration
+	buildMatrix    repeatFlag
+	parallelism    int
+	goIncremental  bool
+	templateCmds   bool
+	templateVars   repeatFlag
+	watchCmd       string
+	watchInterval  time.Duration
+	watchTimeout   time.Duration
+	shadowBuild    bool
+	shadowOutputs  listFlag
+	runCmds        repeatFlag
+	buildWrapper   string
+	runWrapper     string
+	attachStdin    bool
+	appStdout      string
+	crashTail      int
+	preRunDelay    time.Duration
+	readyCmd       string
+	readyTimeout   time.Duration
+	waitFor        repeatFlag
+	waitTimeout    time.Duration
+	noTargetCheck  bool
+	preStop        string
+	drainTimeout   time.Duration
+	runStopSignal  string
+	buildTimeout   time.Duration
+	buildKill      string
+	buildLadder    string
+	stopLadder     string
+	reloadSignal   string
+	reloadOn       string
+	buildNice      int
+	runNice        int
+	buildMemory    string
+	runMemory      string
+	depFile        string
+	depCmd         string
+	deps           repeatFlag
+	interval       time.Duration
+	includes       string
+	excludes       string
+	stripANSI      string
+	serialize      bool
+	statusLine     bool
+	httpAddr       string
+	httpToken      string
+	tlsCert        string
+	tlsKey         string
+	trackResources bool
+	hashAlgo       string
+	verbose        bool
+	healthURL      string
+	healthInterval time.Duration
+	healthFailures int
+	triggerFile    string
+	watchGit       bool
+	stateFile      string
+	debounce       time.Duration
+	settleTime     time.Duration
+	largeChange    int
+	largeSettle    time.Duration
+	cleanCmd       string
+	quietSchedule  repeatFlag
+	quietTZ        string
+	dedupeSaves    bool
+	debounceRules  listFlag
+	rebuildResume  bool
+	maxIdle        time.Duration
+	symlinkTargets bool
+	hashInclude    string
+	maxFiles       int
+	hashDirs       bool
+	generatedPaths string
+	restartGrace   time.Duration
+	graceAllow     string
+	absorbWrites   bool
+	hashMode       bool
+	hashContent    bool
+	dirPrescan     bool
+	skipBuild      bool
+	failFast       bool
+	ensureDirs     bool
+	sectionMarks   string
+	asyncDeps      string
+	cleanExit      string
+	buildLock      string
+	buildLockWait  time.Duration
+	buildSteps     repeatFlag
+	scopes         repeatFlag
+	changeSource   string
+	watchSelf      bool
+	logSinks       repeatFlag
+	contentExclude string
+	failOnStderr   bool
+	failPattern    string
+	successPattern string
+	errorPattern   string
+	warnPattern    string
+	maxWarnings    int
+	noRestartCode  int
+	contentBytes   int
+	maxRuntime     time.Duration
+	checkConfig    bool
+	dumpConfig     bool
+	force          bool
+	listFiles      bool
+	manifest       string
+	verifyManifest bool
+	daemon         bool
+	logFile        string
+	pidFile        string
+	stopDaemon     bool
+	daemonStatus   bool
+	showHistory    bool
+	historySize    int
+
+	configFile string
+	profile    string
+	envErrs    []error // invalid POLY_* values and config file settings, reported by validate
+}
+
+func parseConfig() *config {
+	c := &config{}
+	flag.Var(&c.roots, "root", "Directory to watch; comma-separated or repeated to watch several trees (default \".\")")
+	flag.StringVar(&c.buildCmd, "build", "echo 'No build command specified'", "Build command to run on change")
+	flag.BoolVar(&c.ensureDirs, "ensure-dirs", false, "Create the build's output directories before building if they are missing: those of path-style run targets (e.g. dist/ for --run ./dist/app) and of the build command's -o, --output or --outDir path")
+	flag.BoolVar(&c.failFast, "fail-fast", false, "Stop the app and exit with the build's exit code on the first failed build (after any --build-retries), or with 8 if it didn't get to exit")
+	flag.BoolVar(&c.skipBuild, "skip-initial-build", false, "Start the app from the existing build instead of building at startup; later changes build and restart as usual. If a path-style run target (e.g. ./bin/app) is missing, it is built anyway unless --no-run-target-check")
+	flag.StringVar(&c.checkCmd, "check", "", "Fast check run before the build (e.g. 'go vet ./...'); on failure the build is skipped and the app keeps running")
+	flag.Var(&c.buildSteps, "build-step", "Extra build command as name=command, repeatable, run in order after --build and judged like it (e.g. --build-step 'web=npm run build'); a failing step fails the build")
+	flag.Var(&c.scopes, "scope", "Files an action concerns as action=rules, repeatable, with rules matched like --include and '!rule' to leave files out (e.g. --scope 'web=web/,!web/dist/'); the action (build for --check and --build, a --build-step name, or a run process: app, or app1, app2... with several --run) is skipped, or the process left running, when no changed file is in its scope. --include and --exclude still apply first, and unscoped actions concern every change")
+	flag.IntVar(&c.buildRetries, "build-retries", 0, "Retry a failed build this many times before giving up (for flaky builds)")
+	flag.DurationVar(&c.retryBackoff, "build-retry-backoff", 2*time.Second, "Wait before the first build retry; doubles after each attempt")
+	flag.DurationVar(&c.heartbeat, "build-heartbeat", 0, "Log how long the build has been running at this interval while it runs (e.g. 30s; 0 = off)")
+	flag.Var(&c.buildMatrix, "build-matrix", "Matrix entry as space-separated NAME=value pairs (e.g. 'GOOS=linux GOARCH=arm64'), repeatable; the build runs once per entry with its environment added and fails if any entry fails")
+	flag.IntVar(&c.parallelism, "build-parallelism", 1, "How many --build-matrix entries build at once")
+	flag.StringVar(&c.watchCmd, "watch-cmd", "", "Watch exactly the files this command prints, one path per line, instead of walking --root; include and exclude rules don't apply (e.g. go list -f '{{range .GoFiles}}{{$.Dir}}/{{.}}{{println}}{{end}}' ./...)")
+	flag.DurationVar(&c.watchInterval, "watch-cmd-interval", 30*time.Second, "How often to rerun --watch-cmd for a fresh file list; it also reruns after a dep command, and a failing run keeps the last list")
+	flag.DurationVar(&c.watchTimeout, "watch-cmd-timeout", defaultWatchCmdTimeout, "Kill a --watch-cmd run that takes longer than this and keep the last list; scanning waits for it")
+	flag.Var(&c.logSinks, "log-sink", "Also send events and build and app output to syslog (the local daemon), syslog://host:port (UDP), syslog+tcp://host:port, journald, or a Loki push URL (http://host:3100/loki/api/v1/push); records are dropped rather than delaying builds when it can't keep up; repeatable")
+	flag.BoolVar(&c.watchSelf, "watch-self", false, "Re-exec poly-watcher with the same arguments when its config file or its own binary changes, once the new one passes --check-config. The app is stopped and started again by the new process (its output pipes can't be handed over); re-execs are at least 10s apart. Not on Windows")
+	flag.StringVar(&c.changeSource, "change-source-cmd", "", "Long-running command whose every stdout line requests a rebuild, alongside file watching: plain text is logged as the reason, or a JSON object {\"reason\": ..., \"files\": [...]} also names the changed files. Restarted with backoff if it exits")
+	flag.BoolVar(&c.templateCmds, "template", false, "Expand the build, check and run commands, and --shadow-output paths, as Go text/template templates before each run (run targets are checked as expanded): {{.Name}} (build, check, app, app1...), {{.Workdir}}, {{.Root}}, {{.Changed}} (files changed since the last successful build; empty for the first), {{.Matrix}}, {{.Profile}} and every --var. Values go in verbatim: quote them with {{shquote .X}}, join lists with {{join .Changed \" \"}}, and write a literal {{ as {{\"{{\"}}")
+	flag.Var(&c.templateVars, "var", "Template variable for --template as Name=value, e.g. Port=8080 for --run='./app --port={{.Port}}'; repeatable")
+	flag.BoolVar(&c.goIncremental, "go-incremental", false, "Pass the Go packages affected since the last successful build (changed packages and their dependents, including packages whose tests import them, from go list) to the build as $POLY_GO_PACKAGES, or ./... when that can't be narrowed down (e.g. --build 'go test $POLY_GO_PACKAGES')")
+	flag.BoolVar(&c.shadowBuild, "shadow-build", false, "Build in a hard-linked copy of the root (without hidden directories or --exclude paths) and move --shadow-output into place only on success, so the app never sees half-written artifacts; costs a full tree walk and one link per file on every build, and sources the build edits in place are edited through the link")
+	flag.Var(&c.shadowOutputs, "shadow-output", "Build output to move into place after a shadow build, relative to the root (the shadow build runs from the root's copy); comma-separated or repeated, and expanded like the build command with --template")
+	flag.Var(&c.runCmds, "run", "Run command to execute built app; repeat to start several processes from one build (e.g. a server and a worker), each with prefixed output (default \"echo 'No run command specified'\")")
+	flag.StringVar(&c.buildWrapper, "build-wrapper", "", "Command prepended to the build command (e.g. 'time')")
+	flag.StringVar(&c.runWrapper, "run-wrapper", "", "Command prepended to the run command (e.g. 'dlv exec --headless --listen=:2345 --')")
+	flag.DurationVar(&c.preRunDelay, "pre-run-delay", 0, "Wait this long after a build before starting the app (e.g. for a file lock to be released)")
+	flag.Var(&c.waitFor, "wait-for", "Condition that must hold before the app starts, repeatable: tcp:host:port (accepts connections), file:path (exists) or cmd:command (succeeds)")
+	flag.DurationVar(&c.waitTimeout, "wait-timeout", 30*time.Second, "Give up on --wait-for after this long and don't start the app")
+	flag.IntVar(&c.crashTail, "crash-tail-lines", 0, "Keep the app's last N output lines and log them (and show them on GET /status) when it crashes; the app's output then goes through a pipe rather than straight to the terminal (0 = off)")
+	flag.StringVar(&c.readyCmd, "ready-cmd", "", "Command polled after the app starts until it exits 0, marking the app ready (e.g. 'pg_isready -h localhost'); health checks wait for it")
+	flag.DurationVar(&c.readyTimeout, "ready-timeout", 30*time.Second, "Stop polling --ready-cmd after this long; the app keeps running")
+	flag.StringVar(&c.appStdout, "app-stdout", "", "Append the app's stdout to this file, opened once and kept open across restarts so they never truncate it (instead of '> file' in --run)")
+	flag.BoolVar(&c.attachStdin, "attach-stdin", false, "Connect the terminal's stdin to the running app (for REPLs and prompts)")
+	flag.BoolVar(&c.noTargetCheck, "no-run-target-check", false, "Don't verify that a path-style run target (e.g. ./myapp) exists and is executable after a build")
+	flag.StringVar(&c.preStop, "pre-stop", "", "Command run before the app is stopped for a restart or shutdown (e.g. to flip a load balancer health flag)")
+	flag.DurationVar(&c.drainTimeout, "drain-timeout", 0, "Send --run-stop-signal and give the app this long to drain and exit before killing it (0 = kill immediately)")
+	flag.StringVar(&c.buildLock, "build-lock", "", "Lock file every build holds (flock), so watchers or manual builds sharing an output take turns; a crashed holder's lock is released by the OS. Not on Windows")
+	flag.DurationVar(&c.buildLockWait, "build-lock-timeout", 30*time.Second, "How long a build waits for --build-lock before it is skipped and retried once the watcher is idle")
+	flag.StringVar(&c.cleanExit, "verify-clean-exit", "off", "When the app exits on --drain-timeout's signal or a --run-stop-ladder step for a restart or shutdown, check that nothing it started is left running in its process group, listing the pids and command lines of any survivors before they are killed: off, warn, or fail (also stop the watcher, exiting with 7); catches apps that leak child processes, e.g. in CI. Not on Windows")
+	flag.StringVar(&c.runStopSignal, "run-stop-signal", "TERM", "Signal that starts the app's --drain-timeout (HUP, INT, QUIT, KILL or TERM); it goes to the app's whole process group")
+	flag.StringVar(&c.stopLadder, "run-stop-ladder", "", "Signals for stopping the app, each with how long to wait for it to exit, e.g. 'TERM:10s,INT:5s'; KILL follows the last step. Replaces --run-stop-signal and --drain-timeout")
+	flag.DurationVar(&c.buildTimeout, "build-timeout", 0, "Fail a build still running after this long, sending --build-kill-signal to its process group (0 = no limit)")
+	flag.StringVar(&c.reloadSignal, "reload-signal", "HUP", "Signal sent to the app's process group, instead of restarting it, after a build for changes that only touch --reload-on files")
+	flag.StringVar(&c.reloadOn, "reload-on", "", "Comma-separated rules, matched like --include, for files the app hot-reloads itself (e.g. 'config/,.yaml,assets/'): when only these change, the build runs and the app gets --reload-signal instead of a restart")
+	flag.IntVar(&c.buildNice, "build-nice", 0, "Run builds at this niceness (1-19, lower priority; on Linux the I/O priority follows under CFQ/BFQ), so a heavy build doesn't starve a shared machine; 0 leaves it alone")
+	flag.IntVar(&c.runNice, "run-nice", 0, "Run the app at this niceness (1-19); 0 leaves it alone")
+	flag.StringVar(&c.buildMemory, "build-memory-limit", "", "Virtual memory limit for builds, applied with ulimit -v to everything the build starts (e.g. 4G); skipped with a warning where unsupported")
+	flag.StringVar(&c.runMemory, "run-memory-limit", "", "Virtual memory limit for the app, as for --build-memory-limit (e.g. 1G)")
+	flag.StringVar(&c.buildKill, "build-kill-signal", "KILL", "Signal for a build that exceeds --build-timeout; anything but KILL is followed by KILL 5s later")
+	flag.StringVar(&c.buildLadder, "build-kill-ladder", "", "Signals for a build that exceeds --build-timeout, each with how long to wait for it to exit, e.g. 'TERM:10s,INT:5s'; KILL follows the last step. Replaces --build-kill-signal")
+	flag.StringVar(&c.depFile, "depfile", "", "Dependency file to monitor for changes (e.g. go.mod, package.json)")
+	flag.StringVar(&c.depCmd, "depcommand", "", "Command to run when dependency file changes (e.g. 'go mod tidy', 'npm install')")
+	flag.Var(&c.deps, "dep", "Dependency rule as file=command, repeatable (e.g. --dep 'package.json=npm ci'); changed files' commands run once each, in order, before the build")
+	flag.StringVar(&c.asyncDeps, "async-deps", "", "Run changed dep commands in the background instead of before everything else, waiting for them only at the first step that needs them: build (the check command overlaps with them) or run (the build does too, for deps only the app uses, e.g. npm ci next to a Go build)")
+	flag.DurationVar(&c.interval, "interval", 1*time.Second, "Polling interval (e.g. 1s, 500ms); 0 polls as fast as allowed, every 50ms")
+	flag.DurationVar(&c.debounce, "debounce", 0, "Wait until the tree has been quiet this long before building (0 = build immediately)")
+	flag.IntVar(&c.largeChange, "large-change-threshold", 0, "Treat a batch of more than this many changed files (a branch switch, regenerated code) as a large change: log it, wait for --large-change-settle, and do a full rebuild, running --clean first and ignoring --scope, --go-incremental and --reload-on (0 = never)")
+	flag.DurationVar(&c.largeSettle, "large-change-settle", 0, "How long the tree must be quiet after a large change before building, instead of the --debounce window")
+	flag.StringVar(&c.cleanCmd, "clean", "", "Command run before the build of a large change (see --large-change-threshold), e.g. 'rm -rf dist'")
+	flag.DurationVar(&c.settleTime, "settle-time", 0, "Before the first build, wait until the tree has been unchanged this long (for freshly cloned or still-syncing trees; 0 = build immediately)")
+	flag.Var(&c.quietSchedule, "quiet-schedule", "Time window during which changes are recorded but not built, as '[days ]HH:MM-HH:MM' (e.g. 'Mon-Fri 14:00-15:30', 'Sat,Sun 00:00-24:00', '22:00-06:00'); pending changes build when it ends; repeatable")
+	flag.StringVar(&c.quietTZ, "quiet-tz", "", "Time zone for --quiet-schedule as an IANA name (e.g. Europe/Berlin; needs the system time zone database); default local time")
+	flag.BoolVar(&c.dedupeSaves, "dedupe-saves", false, "Don't rebuild when every modified file has the same content as at its last change, e.g. the second half of an editor's write-then-rename save; reads each modified file once per change")
+	flag.Var(&c.debounceRules, "debounce-rule", "Per-file debounce window as glob=duration, matched against the path relative to its root or the base name (e.g. '*.go=50ms,dist/*=1s'); the largest window in a batch wins")
+	flag.StringVar(&c.includes, "include", "", "Comma-separated list of include rules; when set, only matching files are watched. Name rules without a '/' match a file or directory name anywhere ('Makefile', '*.go', '.go' for a suffix); path rules with a '/' are anchored at the root as a prefix or glob, and directories none can reach aren't walked (e.g. '.go,services,cmd/api/')")
+	flag.StringVar(&c.excludes, "exclude", "", "Comma-separated list of exclude rules, matched like --include (e.g. 'vendor,tmp,*_test.go')")
+	flag.BoolVar(&c.statusLine, "status-line", false, "Keep a one-line summary (e.g. '✓ up · last build 1.2s · 342 files · watching') at the bottom of the terminal with logs scrolling above it; implies --serialize-output, and is off when stderr isn't a terminal")
+	flag.StringVar(&c.sectionMarks, "section-markers", sectionNever, "Print a '── build ──' or '── run ──' rule to stdout as each phase starts, ordered with the logs and output (implies --serialize-output): auto (only when stdout is a terminal), always (plain '-- build --' when piped) or never")
+	flag.BoolVar(&c.serialize, "serialize-output", false, "Route watcher logs and build/app output through one writer so lines from different sources never interleave mid-line; children then write to a pipe rather than the terminal")
+	flag.StringVar(&c.stripANSI, "strip-ansi", stripAuto, "Strip ANSI escape codes from build/app output: auto (only for files, pipes and the HTTP log stream), always or never")
+	flag.StringVar(&c.httpAddr, "http", "", "Address for the HTTP status server (e.g. 127.0.0.1:7777); disabled when empty")
+	flag.StringVar(&c.httpToken, "http-auth-token", "", "Bearer token required by the control endpoints (rebuild, restart, pause, resume, run, checkpoint); without it they only accept local requests")
+	flag.StringVar(&c.tlsCert, "tls-cert", "", "TLS certificate file; with --tls-key, serves the HTTP endpoints over HTTPS")
+	flag.StringVar(&c.tlsKey, "tls-key", "", "TLS private key file for --tls-cert")
+	flag.BoolVar(&c.trackResources, "track-resources", false, "Sample the app's memory and CPU usage (Linux only); shown on GET /status and in verbose logs")
+	flag.StringVar(&c.hashAlgo, "hash-algo", defaultHashAlgo, "Algorithm for the tree hash ("+hashAlgoNames()+")")
+	flag.BoolVar(&c.verbose, "verbose", false, "Enable verbose logging (e.g. list every changed file)")
+	flag.StringVar(&c.healthURL, "health-url", "", "URL polled while the app runs; the app is restarted after repeated failures (e.g. http://localhost:8080/healthz)")
+	flag.DurationVar(&c.healthInterval, "health-interval", 5*time.Second, "Interval between health checks")
+	flag.IntVar(&c.healthFailures, "health-failures", 3, "Consecutive failed health checks before the app is restarted")
+	flag.BoolVar(&c.watchGit, "watch-git", false, "Also rebuild when git HEAD moves (checkout, commit, rebase), including detached HEADs and packed refs, even if no watched file changed")
+	flag.StringVar(&c.triggerFile, "trigger-file", "", "File whose creation or modification always forces a rebuild (e.g. .rebuild for 'touch .rebuild'); excluded from the tree hash")
+	flag.BoolVar(&c.failOnStderr, "fail-on-stderr", false, "Treat a build that writes anything to stderr as failed, whatever its exit code")
+	flag.StringVar(&c.failPattern, "fail-pattern", "", "Treat a build as failed if a line of its output matches this regexp, whatever its exit code (e.g. '(?i)^error')")
+	flag.StringVar(&c.successPattern, "success-pattern", "", "Decide build success by output instead of exit code: the build succeeds only if a line of its output matches this regexp (--fail-pattern and --fail-on-stderr still win)")
+	flag.StringVar(&c.errorPattern, "error-pattern", "", "Count build output lines matching this regexp as errors, for GET /status and the build_finished event (default: the error lines of common compilers and linters)")
+	flag.StringVar(&c.warnPattern, "warning-pattern", "", "Count build output lines matching this regexp, and not --error-pattern, as warnings (default: the warning lines of common compilers and linters)")
+	flag.IntVar(&c.maxWarnings, "max-warnings", -1, "Fail a build whose output has more than this many warnings (0 for none at all), e.g. to keep new warnings out before CI runs; -1 disables this")
+	flag.IntVar(&c.noRestartCode, "no-restart-exit-code", 0, "Exit code (1-255, e.g. 75) with which the build reports success but no need to restart: the running app is left alone, or started if it isn't running. Any other nonzero code is still a failure; 0 disables this")
+	flag.StringVar(&c.hashInclude, "hash-include", "", "Comma-separated parts of each file that count as a change: path, size, mtime, content and mode (default path,size,mtime); e.g. path,size,content ignores mtime entirely and path alone only notices added, removed and renamed files. path is required")
+	flag.StringVar(&c.contentExclude, "exclude-content", "", "Skip files whose first --exclude-content-bytes match this regexp (e.g. '(?m)^// Code generated .* DO NOT EDIT\\.$'), to break generator/rebuild loops; costs one read per new or changed file")
+	flag.IntVar(&c.contentBytes, "exclude-content-bytes", defaultContentExcludeBytes, "How many leading bytes of each file --exclude-content checks")
+	flag.DurationVar(&c.restartGrace, "post-restart-grace", 0, "For this long after the app starts, changes only update the baseline instead of rebuilding, so PID files, caches and logs it writes into the tree don't set off another build (0 = off)")
+	flag.StringVar(&c.graceAllow, "post-restart-grace-allow", "", "Comma-separated rules, matched like --include, for source files whose changes rebuild even within --post-restart-grace (e.g. '.go,src/')")
+	flag.StringVar(&c.generatedPaths, "generated-paths", "", "Comma-separated rules, matched like --include, for files the build itself writes (e.g. '*_gen.go,api/gen/'); if a build only changes these, it doesn't trigger another build")
+	flag.BoolVar(&c.absorbWrites, "absorb-build-writes", false, "Treat any file modified while the build ran as written by the build, so it doesn't trigger another build (an edit saved mid-build is then missed)")
+	flag.BoolVar(&c.hashDirs, "hash-dirs", false, "Also treat directories as watched entries, so creating or removing an empty directory triggers a rebuild")
+	flag.BoolVar(&c.hashContent, "hash-content", false, "Detect changes by file content instead of mtime, for trees whose mtimes can't be trusted, such as ones rsync'd from another machine: a rewrite with identical content doesn't rebuild, and an edit that kept size and mtime does (through the inode change time on Linux and macOS). A file is only re-read when its metadata changed")
+	flag.BoolVar(&c.dirPrescan, "dir-mtime-prescan", false, "Reuse a directory's previous listing while its mtime is unchanged, so scans of large, mostly untouched trees only stat the files they already know; files are still checked for in-place edits. Relies on the filesystem bumping a directory's mtime when entries are added, removed or renamed, which NFS with attribute caching, some FUSE and network mounts don't do reliably")
+	flag.BoolVar(&c.hashMode, "hash-mode", false, "Fold permission bits into the hash, so e.g. 'chmod +x' on a script (or a directory, with --hash-dirs) triggers a rebuild")
+	flag.IntVar(&c.maxFiles, "max-files", 100000, "Fail at startup when the first scan finds more files than this, e.g. when --root points at $HOME by mistake; later scans only warn (0 = no limit)")
+	flag.BoolVar(&c.symlinkTargets, "hash-symlink-targets", false, "Fold the size and mtime of each file symlink's target into the hash, so edits to the target trigger a rebuild")
+	flag.StringVar(&c.stateFile, "state-file", "", "File used to remember the last build and dep file hashes across watcher restarts, so an unchanged tree isn't rebuilt")
+	flag.BoolVar(&c.rebuildResume, "rebuild-on-resume", false, "Rebuild on resume if files changed while watching was paused (POST /pause, POST /resume)")
+	flag.DurationVar(&c.maxIdle, "max-idle", 0, "Stop the watcher and app after this long without a change (0 = never)")
+	flag.DurationVar(&c.maxRuntime, "max-runtime", 0, "Stop the watcher and app after running this long (0 = never)")
+	flag.BoolVar(&c.daemon, "daemon", false, "Detach from the terminal and keep watching in the background, logging to --log-file and recording the pid in --pidfile (not supported on Windows; use a service wrapper); with --http, 'poly-watcher logs -f' follows its build and app output")
+	flag.StringVar(&c.logFile, "log-file", "poly-watcher.log", "With --daemon, file the watcher's logs and the build and app output are appended to")
+	flag.StringVar(&c.pidFile, "pidfile", ".poly-watcher.pid", "Pidfile written by --daemon and read by --stop and --status")
+	flag.BoolVar(&c.stopDaemon, "stop", false, "Stop the daemon named by --pidfile and exit")
+	flag.BoolVar(&c.showHistory, "history", false, "Print the recent build cycles of the watcher whose status server is at --http and exit")
+	flag.IntVar(&c.historySize, "history-size", 50, "Number of recent build cycles GET /history keeps (0 disables it)")
+	flag.BoolVar(&c.daemonStatus, "status", false, "Report whether the daemon named by --pidfile is running and exit (exit 1 if not)")
+	flag.StringVar(&c.configFile, "config", "", "Config file of flag-name: value settings, as written by 'poly-watcher init' (default \"poly.yaml\" if present)")
+	flag.StringVar(&c.profile, "profile", "", "Named profile to apply: its settings under profiles: in the config file and its POLY_PROFILE_<NAME>_<FLAG> variables (e.g. POLY_PROFILE_DEBUG_RUN_WRAPPER) override the base ones")
+	flag.StringVar(&c.manifest, "manifest", "", "Scan once, write the sha256 of every watched file's content to this file (sha256sum format) and exit")
+	flag.BoolVar(&c.verifyManifest, "manifest-verify", false, "With --manifest, compare the tree against the file instead of writing it, list what differs and exit with 6 on any drift (e.g. in CI)")
+	flag.BoolVar(&c.listFiles, "list-files", false, "Scan once, print every watched path (sorted) and exit; with --verbose also print skipped paths and why")
+	flag.BoolVar(&c.checkConfig, "check-config", false, "Validate the settings, print the effective configuration and exit")
+	flag.BoolVar(&c.dumpConfig, "dump-config", false, "Validate the settings, write the effective configuration (flags, environment, config file and defaults) to "+defaultConfigFile+" and exit; it is read back on the next run")
+	flag.BoolVar(&c.force, "force", false, "With --dump-config, overwrite an existing "+defaultConfigFile+" this run didn't read")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintln(flag.CommandLine.Output(), "\nEvery flag can also be set through the environment as POLY_<NAME>, e.g. POLY_BUILD or POLY_HEALTH_URL.")
+		fmt.Fprintln(flag.CommandLine.Output(), "With --profile NAME, POLY_PROFILE_<NAME>_<FLAG> and the config file's profiles: NAME: section take precedence over")
+		fmt.Fprintln(flag.CommandLine.Output(), "the base settings: flags > profile variables > config file profile > environment > config file > defaults.")
+		fmt.Fprintln(flag.CommandLine.Output(), "\nRun 'poly-watcher init' to write a starter poly.yaml for the project in the current directory.")
+	}
+	flag.Parse()
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	c.envErrs = append(c.envErrs, loadSettings(flag.CommandLine, set)...)
+
+	if len(c.runCmds) == 0 {
+		c.runCmds = repeatFlag{"echo 'No run command specified'"}
+	}
+	if len(c.roots) == 0 {
+		c.roots = listFlag{"."}
+	}
+	return c
+}
+
+// envName maps a flag name to its environment variable, e.g. health-url to
+// POLY_HEALTH_URL.
+func envName(flagName string) string {
+	return "POLY_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+func profilePrefix(profile string) string {
+	return envName("profile-"+profile) + "_"
+}
+
+// profileExists reports whether any variable belongs to profile.
+func profileExists(profile string) bool {
+	prefix := profilePrefix(profile)
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadSettings sets every flag of fs not in set (those given on the command
+// line) from the environment and the config file: the --profile's
+// POLY_PROFILE_<NAME>_* variables first, then its section under profiles:
+// in the config file, then the base POLY_* variables, then the file's base
+// settings. So a profile wins over the base settings wherever either comes
+// from. Flags it sets are added to set.
+func loadSettings(fs *flag.FlagSet, set map[string]bool) []error {
+	var errs []error
+	profile := fs.Lookup("profile").Value.String()
+	if profile == "" {
+		profile = os.Getenv(envName("profile"))
+		_ = fs.Set("profile", profile)
+	}
+	if profile != "" {
+		errs = append(errs, applyEnv(fs, profilePrefix(profile), set)...)
+	}
+
+	path := fs.Lookup("config").Value.String()
+	if path == "" {
+		path = os.Getenv(envName("config"))
+	}
+	if path == "" {
+		if _, err := os.Stat(defaultConfigFile); err == nil {
+			path = defaultConfigFile
+		}
+	}
+	_ = fs.Set("config", path)
+	var file *configFile
+	if path != "" {
+		var err error
+		if file, err = parseConfigFile(path); err != nil {
+			errs = append(errs, fmt.Errorf("reading config: %v", err))
+		}
+	}
+
+	if profile != "" {
+		var section []configEntry
+		inFile := false
+		if file != nil {
+			section, inFile = file.profiles[profile]
+		}
+		if !inFile && !profileExists(profile) {
+			where := "no " + profilePrefix(profile) + "* variables are set"
+			if file != nil {
+				where += " and " + path + " has no such profile"
+			}
+			errs = append(errs, fmt.Errorf("unknown --profile %q: %s", profile, where))
+		}
+		errs = append(errs, applyConfigEntries(fs, path, section, set)...)
+	}
+	errs = append(errs, applyEnv(fs, "POLY_", set)...)
+	if file != nil {
+		errs = append(errs, applyConfigEntries(fs, path, file.entries, set)...)
+	}
+	return errs
+}
+
+// applyEnv sets every flag not in set from the variable named prefix plus
+// its upper-cased name, e.g. POLY_HEALTH_URL for health-url with the prefix
+// POLY_. Flags it sets are added to set.
+func applyEnv(fs *flag.FlagSet, prefix string, set map[string]bool) []error {
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || f.Name == "profile" {
+			return
+		}
+		name := prefix + strings.TrimPrefix(envName(f.Name), "POLY_")
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		set[f.Name] = true
+		if err := f.Value.Set(value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %v", name, err))
+		}
+	})
+	return errs
+}
+
+// validate reports every problem with the settings rather than stopping at
+// the first.
+func (c *config) validate() []error {
+	errs := append([]error{}, c.envErrs...)
+	if strings.TrimSpace(c.buildCmd) == "" {
+		errs = append(errs, fmt.Errorf("--build must not be empty"))
+	}
+	for _, runCmd := range c.runCmds {
+		if strings.TrimSpace(runCmd) == "" {
+			errs = append(errs, fmt.Errorf("--run must not be empty"))
+		}
+	}
+	if c.interval < 0 {
+		errs = append(errs, fmt.Errorf("--interval must not be negative"))
+	}
+	for _, root := range c.roots {
+		info, err := os.Stat(root)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid --root: %v", err))
+		} else if !info.IsDir() {
+			errs = append(errs, fmt.Errorf("invalid --root: %s is not a directory", root))
+		}
+	}
+	for _, d := range c.deps {
+		if _, err := parseDepRule(d); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	_, _, scopeErrs := parseScopes(c.buildSteps, c.scopes, len(c.runCmds))
+	errs = append(errs, scopeErrs...)
+	switch c.cleanExit {
+	case "off", "warn", "fail":
+	default:
+		errs = append(errs, fmt.Errorf("invalid --verify-clean-exit %q, want off, warn or fail", c.cleanExit))
+	}
+	if c.cleanExit != "off" && c.drainTimeout <= 0 && c.stopLadder == "" {
+		errs = append(errs, fmt.Errorf("--verify-clean-exit needs --drain-timeout or --run-stop-ladder; without them the app's whole process group is killed at once"))
+	}
+	if c.cleanExit != "off" && c.attachStdin {
+		errs = append(errs, fmt.Errorf("--verify-clean-exit can't be combined with --attach-stdin, which keeps the app out of a process group of its own"))
+	}
+	if c.buildLockWait <= 0 {
+		errs = append(errs, fmt.Errorf("--build-lock-timeout must be positive"))
+	}
+	switch c.asyncDeps {
+	case "", "build", "run":
+	default:
+		errs = append(errs, fmt.Errorf("invalid --async-deps %q, want build or run", c.asyncDeps))
+	}
+	if c.asyncDeps != "" && (c.depFile == "" || c.depCmd == "") && len(c.deps) == 0 {
+		errs = append(errs, fmt.Errorf("--async-deps needs --dep or --depfile"))
+	}
+	if c.asyncDeps == "run" && c.shadowBuild {
+		errs = append(errs, fmt.Errorf("--async-deps run can't be combined with --shadow-build, which copies the tree while the dep commands write to it"))
+	}
+	if c.buildRetries < 0 || c.retryBackoff < 0 {
+		errs = append(errs, fmt.Errorf("--build-retries and --build-retry-backoff must not be negative"))
+	}
+	if c.shadowBuild {
+		if len(c.roots) != 1 {
+			errs = append(errs, fmt.Errorf("--shadow-build needs exactly one --root"))
+		}
+		if len(c.shadowOutputs) == 0 {
+			errs = append(errs, fmt.Errorf("--shadow-build needs at least one --shadow-output"))
+		}
+		for _, out := range c.shadowOutputs {
+			if filepath.IsAbs(out) || !filepath.IsLocal(out) {
+				errs = append(errs, fmt.Errorf("--shadow-output %q must be a path inside the root", out))
+			}
+		}
+	}
+	if c.contentExclude != "" {
+		if _, err := regexp.Compile(c.contentExclude); err != nil {
+			errs = append(errs, fmt.Errorf("invalid --exclude-content: %v", err))
+		}
+	}
+	if c.historySize < 0 {
+		errs = append(errs, fmt.Errorf("--history-size must not be negative"))
+	}
+	if c.noRestartCode < 0 || c.noRestartCode > 255 {
+		errs = append(errs, fmt.Errorf("--no-restart-exit-code must be between 1 and 255, or 0 to disable it"))
+	}
+	for name, pattern := range map[string]string{"fail-pattern": c.failPattern, "success-pattern": c.successPattern, "error-pattern": c.errorPattern, "warning-pattern": c.warnPattern} {
+		if _, err := regexp.Compile(pattern); err != nil {
+			errs = append(errs, fmt.Errorf("invalid --%s: %v", name, err))
+		}
+	}
+	if c.maxWarnings < -1 {
+		errs = append(errs, fmt.Errorf("--max-warnings must be -1 (no limit) or more"))
+	}
+	if c.contentBytes <= 0 {
+		errs = append(errs, fmt.Errorf("--exclude-content-bytes must be positive"))
+	}
+	if c.crashTail < 0 {
+		errs = append(errs, fmt.Errorf("--crash-tail-lines must not be negative"))
+	}
+	if c.maxFiles < 0 {
+		errs = append(errs, fmt.Errorf("--max-files must not be negative"))
+	}
+	if c.readyTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("--ready-timeout must be positive"))
+	}
+	if c.preRunDelay < 0 || c.waitTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("--pre-run-delay must not be negative and --wait-timeout must be positive"))
+	}
+	for _, cond := range c.waitFor {
+		if _, err := parseWaitCondition(cond); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, m := range c.buildMatrix {
+		if _, err := parseMatrixEntry(m); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.parallelism < 1 {
+		errs = append(errs, fmt.Errorf("--build-parallelism must be at least 1"))
+	}
+	if c.heartbeat < 0 {
+		errs = append(errs, fmt.Errorf("--build-heartbeat must not be negative"))
+	}
+	if c.drainTimeout < 0 {
+		errs = append(errs, fmt.Errorf("--drain-timeout must not be negative"))
+	}
+	for name, nice := range map[string]int{"build-nice": c.buildNice, "run-nice": c.runNice} {
+		if nice < 0 || nice > 19 {
+			errs = append(errs, fmt.Errorf("--%s must be between 0 and 19", name))
+		}
+	}
+	for name, size := range map[string]string{"build-memory-limit": c.buildMemory, "run-memory-limit": c.runMemory} {
+		if size == "" {
+			continue
+		}
+		if _, err := parseByteSize(size); err != nil {
+			errs = append(errs, fmt.Errorf("--%s: %v", name, err))
+		}
+	}
+	if c.buildTimeout < 0 {
+		errs = append(errs, fmt.Errorf("--build-timeout must not be negative"))
+	}
+	for name, sig := range map[string]string{"run-stop-signal": c.runStopSignal, "build-kill-signal": c.buildKill, "reload-signal": c.reloadSignal} {
+		if _, err := parseSignal(sig); err != nil {
+			errs = append(errs, fmt.Errorf("--%s: %v", name, err))
+		}
+	}
+	for _, spec := range c.logSinks {
+		if _, err := parseLogSink(spec); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.verifyManifest && c.manifest == "" {
+		errs = append(errs, fmt.Errorf("--manifest-verify needs --manifest"))
+	}
+	if c.force && !c.dumpConfig {
+		errs = append(errs, fmt.Errorf("--force needs --dump-config"))
+	}
+	if c.buildLadder != "" {
+		if _, err := parseLadder(c.buildLadder); err != nil {
+			errs = append(errs, fmt.Errorf("--build-kill-ladder: %v", err))
+		}
+		if c.buildTimeout == 0 {
+			errs = append(errs, fmt.Errorf("--build-kill-ladder needs --build-timeout"))
+		}
+	}
+	if c.stopLadder != "" {
+		if _, err := parseLadder(c.stopLadder); err != nil {
+			errs = append(errs, fmt.Errorf("--run-stop-ladder: %v", err))
+		}
+		if c.drainTimeout > 0 {
+			errs = append(errs, fmt.Errorf("--run-stop-ladder and --drain-timeout are mutually exclusive; give the ladder's first step the drain time"))
+		}
+	}
+	if c.debounce < 0 {
+		errs = append(errs, fmt.Errorf("--debounce must not be negative"))
+	}
+	if c.watchCmd != "" && (c.watchInterval <= 0 || c.watchTimeout <= 0) {
+		errs = append(errs, fmt.Errorf("--watch-cmd-interval and --watch-cmd-timeout must be positive"))
+	}
+	for _, q := range c.quietSchedule {
+		if _, err := parseQuietWindow(q); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.quietTZ != "" {
+		if _, err := time.LoadLocation(c.quietTZ); err != nil {
+			errs = append(errs, fmt.Errorf("invalid --quiet-tz: %v", err))
+		}
+	}
+	if c.restartGrace < 0 {
+		errs = append(errs, fmt.Errorf("--post-restart-grace must not be negative"))
+	}
+	for _, v := range c.templateVars {
+		if _, _, err := parseTemplateVar(v); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.templateCmds {
+		commands := append([]string{c.buildCmd, c.checkCmd}, c.runCmds...)
+		for _, s := range c.buildSteps {
+			if step, err := parseBuildStep(s); err == nil {
+				commands = append(commands, step.command)
+			}
+		}
+		for _, command := range commands {
+			if _, err := parseCommandTemplate("command", command); err != nil {
+				errs = append(errs, fmt.Errorf("--template: %v", err))
+			}
+		}
+	} else if len(c.templateVars) > 0 {
+		errs = append(errs, fmt.Errorf("--var needs --template"))
+	}
+	if c.settleTime < 0 {
+		errs = append(errs, fmt.Errorf("--settle-time must not be negative"))
+	}
+	if c.largeChange < 0 {
+		errs = append(errs, fmt.Errorf("--large-change-threshold must not be negative"))
+	}
+	if c.largeSettle < 0 {
+		errs = append(errs, fmt.Errorf("--large-change-settle must not be negative"))
+	}
+	if (c.largeSettle > 0 || c.cleanCmd != "") && c.largeChange == 0 {
+		errs = append(errs, fmt.Errorf("--large-change-settle and --clean need --large-change-threshold"))
+	}
+	for _, r := range c.debounceRules {
+		if _, err := parseDebounceRule(r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.maxIdle < 0 || c.maxRuntime < 0 {
+		errs = append(errs, fmt.Errorf("--max-idle and --max-runtime must not be negative"))
+	}
+	if err := validSectionMode(c.sectionMarks); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validStripMode(c.stripANSI); err != nil {
+		errs = append(errs, err)
+	}
+	if (c.tlsCert == "") != (c.tlsKey == "") {
+		errs = append(errs, fmt.Errorf("--tls-cert and --tls-key must be given together"))
+	}
+	for _, f := range []string{c.tlsCert, c.tlsKey} {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			errs = append(errs, fmt.Errorf("invalid TLS file: %v", err))
+		}
+	}
+	if _, err := newHashFunc(c.hashAlgo); err != nil {
+		errs = append(errs, err)
+	}
+	if c.hashInclude != "" {
+		parts, err := parseHashInclude(c.hashInclude)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if c.hashContent || c.hashMode {
+			errs = append(errs, fmt.Errorf("--hash-include can't be combined with --hash-content or --hash-mode; list content or mode in it instead"))
+		}
+		if err == nil && !slices.Contains(parts, "mtime") && (c.dedupeSaves || c.absorbWrites) {
+			errs = append(errs, fmt.Errorf("--dedupe-saves and --absorb-build-writes go by mtime, so --hash-include must include it"))
+		}
+	}
+	if c.healthURL != "" {
+		if u, err := url.Parse(c.healthURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			errs = append(errs, fmt.Errorf("--health-url must be an http or https URL"))
+		}
+		if c.healthInterval <= 0 {
+			errs = append(errs, fmt.Errorf("--health-interval must be positive"))
+		}
+		if c.healthFailures < 1 {
+			errs = append(errs, fmt.Errorf("--health-failures must be at least 1"))
+		}
+	}
+	return errs
+}
+
+// printSummary writes every setting with its effective value, one per line
+// in flag-name order.
+func (c *config) printSummary(out io.Writer) {
+	flag.VisitAll(func(f *flag.Flag) {
+		switch f.Name {
+		case "check-config", "dump-config", "force", "list-files", "manifest", "manifest-verify", "stop", "status", "history":
+			return
+		}
+		value := f.Value.String()
+		if f.Name == "http-auth-token" && value != "" {
+			value = "<redacted>"
+		}
+		fmt.Fprintf(out, "%s=%s\n", f.Name, value)
+	})
+}
+
+func splitRules(rules string) []string {
+	if rules == "" {
+		return []string{}
+	}
+	return strings.Split(rules, ",")
+}
+
+// fingerprint hashes the settings that decide what gets built and how, so
+// state written under different settings is not reused.
+func (c *config) fingerprint() string {
+	h := sha256.New()
+	for _, v := range []string{
+		c.roots.String(), c.buildCmd, c.checkCmd, c.runCmds.String(), c.buildWrapper,
+		c.runWrapper, c.depFile, c.depCmd, c.deps.String(), c.includes, c.excludes, c.hashAlgo,
+		c.contentExclude, fmt.Sprint(c.contentBytes), c.buildMatrix.String(),
+		fmt.Sprint(c.hashDirs, c.hashMode, c.hashContent), c.hashInclude,
+		fmt.Sprint(c.symlinkTargets), fmt.Sprint(c.goIncremental), c.watchCmd,
+		fmt.Sprint(c.templateCmds), c.templateVars.String(), c.buildSteps.String(),
+		c.scopes.String(),
+	} {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// newWatcher builds a Watcher from validated settings.
+func (c *config) newWatcher() *Watcher {
+	newHash, _ := newHashFunc(c.hashAlgo)
+
+	w := NewWatcher(c.roots, c.interval, c.buildCmd, c.runCmds[0], c.depFile, c.depCmd, splitRules(c.includes), splitRules(c.excludes))
+	for _, d := range c.deps {
+		rule, _ := parseDepRule(d)
+		w.depRules = append(w.depRules, rule)
+	}
+	w.runCmds = slices.Clone(c.runCmds)
+	w.checkCmd = c.checkCmd
+	w.buildRetries = c.buildRetries
+	w.buildHeartbeat = c.heartbeat
+	for _, m := range c.buildMatrix {
+		entry, _ := parseMatrixEntry(m)
+		w.buildMatrix = append(w.buildMatrix, entry)
+	}
+	w.buildParallelism = c.parallelism
+	w.goIncremental = c.goIncremental
+	w.templateCmds = c.templateCmds
+	w.profile = c.profile
+	if len(c.templateVars) > 0 {
+		w.templateVars = make(map[string]string, len(c.templateVars))
+		for _, v := range c.templateVars {
+			name, value, _ := parseTemplateVar(v)
+			w.templateVars[name] = value
+		}
+	}
+	w.watchCmd = c.watchCmd
+	w.watchCmdInterval = c.watchInterval
+	w.watchCmdTimeout = c.watchTimeout
+	w.preRunDelay = c.preRunDelay
+	w.readyCmd = c.readyCmd
+	w.readyTimeout = c.readyTimeout
+	w.waitTimeout = c.waitTimeout
+	for _, cond := range c.waitFor {
+		wc, _ := parseWaitCondition(cond)
+		w.waitFor = append(w.waitFor, wc)
+	}
+	w.maxFiles = c.maxFiles
+	w.hashDirs = c.hashDirs
+	w.watchGit = c.watchGit
+	w.generatedPaths = splitRules(c.generatedPaths)
+	w.restartGrace = c.restartGrace
+	w.graceAllow = splitRules(c.graceAllow)
+	w.absorbWrites = c.absorbWrites
+	w.hashMode = c.hashMode
+	w.hashContent = c.hashContent
+	w.hashMTime = !c.hashContent
+	w.dirMtimePrescan = c.dirPrescan
+	w.skipInitialBuild = c.skipBuild
+	w.failFast = c.failFast
+	w.ensureDirs = c.ensureDirs
+	w.asyncDeps = c.asyncDeps
+	if c.cleanExit != "off" && groupTrackingSupported {
+		w.cleanExit = c.cleanExit
+	}
+	if c.buildLock != "" && buildLockSupported {
+		w.buildLock = c.buildLock
+	}
+	w.buildLockTimeout = c.buildLockWait
+	w.steps, w.scopes, _ = parseScopes(c.buildSteps, c.scopes, len(c.runCmds))
+	w.changeSourceCmd = c.changeSource
+	w.watchSelf = c.watchSelf
+	for _, spec := range c.logSinks {
+		sink, _ := parseLogSink(spec)
+		w.logSinks = append(w.logSinks, sink)
+	}
+	if c.watchSelf && c.configFile != "" {
+		w.selfPaths = []string{c.configFile}
+	}
+	w.crashTailLines = c.crashTail
+	w.failOnStderr = c.failOnStderr
+	w.noRestartCode = c.noRestartCode
+	w.runStopSignal, _ = parseSignal(c.runStopSignal)
+	w.buildTimeout = c.buildTimeout
+	w.buildNice, w.runNice = c.buildNice, c.runNice
+	w.reloadSignal, _ = parseSignal(c.reloadSignal)
+	w.reloadOn = splitRules(c.reloadOn)
+	if c.buildMemory != "" {
+		w.buildMemory, _ = parseByteSize(c.buildMemory)
+	}
+	if c.runMemory != "" {
+		w.runMemory, _ = parseByteSize(c.runMemory)
+	}
+	w.buildKillSignal, _ = parseSignal(c.buildKill)
+	if c.buildLadder != "" {
+		w.buildLadder, _ = parseLadder(c.buildLadder)
+	}
+	if c.stopLadder != "" {
+		w.stopLadder, _ = parseLadder(c.stopLadder)
+	}
+	w.history.max = c.historySize
+	if c.failPattern != "" {
+		w.failPattern = regexp.MustCompile(c.failPattern)
+	}
+	if c.successPattern != "" {
+		w.successPattern = regexp.MustCompile(c.successPattern)
+	}
+	w.diags = newDiagCounter(c.errorPattern, c.warnPattern)
+	w.maxWarnings = c.maxWarnings
+	if c.contentExclude != "" {
+		w.contentExclude = regexp.MustCompile(c.contentExclude)
+		w.contentExcludeBytes = c.contentBytes
+	}
+	w.shadowBuild = c.shadowBuild
+	for _, out := range c.shadowOutputs {
+		w.shadowOutputs = append(w.shadowOutputs, filepath.Clean(out))
+	}
+	w.buildRetryBackoff = c.retryBackoff
+	w.buildWrapper = c.buildWrapper
+	w.runWrapper = c.runWrapper
+	w.attachStdin = c.attachStdin
+	w.checkTarget = !c.noTargetCheck
+	w.preStop = c.preStop
+	w.drainTimeout = c.drainTimeout
+	w.newHash = newHash
+	w.verbose = c.verbose
+	w.stripANSI = c.stripANSI
+	if c.serialize || c.statusLine || c.sectionMarks != sectionNever {
+		w.mux = &logMux{}
+	}
+	if c.sectionMarks != sectionNever {
+		w.sections = newSectionMarkers(c.sectionMarks, w.mux)
+	}
+	if c.statusLine && isTerminal(os.Stderr) {
+		w.mux.status = &statusLine{out: os.Stderr, atLineStart: true}
+	}
+	w.httpAddr = c.httpAddr
+	w.httpToken = c.httpToken
+	w.tlsCert = c.tlsCert
+	w.tlsKey = c.tlsKey
+	w.trackResources = c.trackResources && resourceTrackingSupported
+	w.healthURL = c.healthURL
+	w.healthInterval = c.healthInterval
+	w.healthFailures = c.healthFailures
+	w.hashSymlinkTargets = c.symlinkTargets
+	if c.hashInclude != "" {
+		parts, _ := parseHashInclude(c.hashInclude)
+		w.hashSize = slices.Contains(parts, "size")
+		w.hashMTime = slices.Contains(parts, "mtime")
+		w.hashContent = slices.Contains(parts, "content")
+		w.hashMode = slices.Contains(parts, "mode")
+	}
+	w.rebuildOnResume = c.rebuildResume
+	w.debounce = c.debounce
+	w.settleTime = c.settleTime
+	w.largeThreshold = c.largeChange
+	w.largeSettle = c.largeSettle
+	w.cleanCmd = c.cleanCmd
+	w.dedupeSaves = c.dedupeSaves
+	for _, q := range c.quietSchedule {
+		window, _ := parseQuietWindow(q)
+		w.quietWindows = append(w.quietWindows, window)
+	}
+	w.quietTZ = time.Local
+	if c.quietTZ != "" {
+		w.quietTZ, _ = time.LoadLocation(c.quietTZ)
+	}
+	for _, r := range c.debounceRules {
+		rule, _ := parseDebounceRule(r)
+		w.debounceRules = append(w.debounceRules, rule)
+	}
+	if c.daemon {
+		w.ownPaths = append(w.ownPaths, filepath.Clean(c.pidFile), filepath.Clean(c.logFile))
+	}
+	if c.manifest != "" {
+		w.ownPaths = append(w.ownPaths, filepath.Clean(c.manifest))
+	}
+	if c.buildLock != "" {
+		w.ownPaths = append(w.ownPaths, filepath.Clean(c.buildLock))
+	}
+	if c.appStdout != "" {
+		w.appStdoutPath = filepath.Clean(c.appStdout)
+		w.ownPaths = append(w.ownPaths, w.appStdoutPath)
+	}
+	if c.stateFile != "" {
+		w.stateFile = filepath.Clean(c.stateFile)
+		w.configHash = c.fingerprint()
+	}
+	w.maxIdle = c.maxIdle
+	w.maxRuntime = c.maxRuntime
+	if c.triggerFile != "" {
+		w.triggerFile = filepath.Clean(c.triggerFile)
+	}
+	return w
+}
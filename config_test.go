@@ -0,0 +1,14 @@
+package main
+
+import "testing"
+
+func TestFingerprintRunCommands(t *testing.T) {
+	one := &config{runCmds: repeatFlag{"a b"}}
+	two := &config{runCmds: repeatFlag{"a", "b"}}
+	if one.fingerprint() == two.fingerprint() {
+		t.Error("--run 'a b' and --run a --run b have the same fingerprint")
+	}
+	if one.runCmds.String() == two.runCmds.String() {
+		t.Errorf("--run 'a b' and --run a --run b are both summarized as %s", one.runCmds.String())
+	}
+}
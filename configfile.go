@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// defaultConfigFile is read from the working directory when --config isn't
+// given; it is what `poly-watcher init` writes.
+const defaultConfigFile = "poly.yaml"
+
+// fileOnlyFlags are the flags a config file may not set: they pick the
+// config itself or are one-shot actions.
+var fileOnlyFlags = map[string]bool{
+	"config": true, "profile": true, "check-config": true, "dump-config": true, "force": true,
+	"list-files": true, "manifest": true, "manifest-verify": true,
+	"stop": true, "status": true, "history": true,
+}
+
+// configEntry is one setting read from a config file.
+type configEntry struct {
+	line   int
+	key    string
+	values []string // several for a block list
+}
+
+// configFile is a parsed config file.
+type configFile struct {
+	entries  []configEntry
+	profiles map[string][]configEntry // the profiles: section, by name
+}
+
+// parseConfigFile reads the small YAML subset poly.yaml uses: one
+// "flag-name: value" per line, or "flag-name:" followed by "- value" items,
+// with # comments. Scalars may be single- or double-quoted. A top-level
+// "profiles:" holds indented "name:" lines, each followed by that
+// profile's settings, indented further.
+func parseConfigFile(path string) (*configFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cf := &configFile{profiles: make(map[string][]configEntry)}
+	var (
+		inList        bool   // the last setting had no scalar value, so items may follow
+		inProfiles    bool   // within the profiles: section
+		profileIndent int    // indentation of its profile names
+		profile       string // the profile settings go to, "" for the base ones
+	)
+	add := func(e configEntry) {
+		if profile == "" {
+			cf.entries = append(cf.entries, e)
+		} else {
+			cf.profiles[profile] = append(cf.profiles[profile], e)
+		}
+	}
+	last := func() *configEntry {
+		entries := cf.entries
+		if profile != "" {
+			entries = cf.profiles[profile]
+		}
+		return &entries[len(entries)-1]
+	}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		text := sc.Text()
+		line := strings.TrimSpace(text)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		indent := len(text) - len(strings.TrimLeft(text, " \t"))
+		item, isItem := strings.CutPrefix(line, "- ")
+		if inProfiles && indent == 0 && !isItem {
+			inProfiles, profile = false, ""
+		}
+		if isItem {
+			if !inList {
+				return nil, fmt.Errorf("%s:%d: list item without a setting", path, n)
+			}
+			v, err := yamlScalar(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+			}
+			e := last()
+			e.values = append(e.values, v)
+			continue
+		}
+		key, raw, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: want \"name: value\"", path, n)
+		}
+		key = strings.TrimSpace(key)
+		raw = strings.TrimSpace(raw)
+		inList = raw == "" || strings.HasPrefix(raw, "#")
+
+		if !inProfiles && key == "profiles" {
+			if !inList {
+				return nil, fmt.Errorf("%s:%d: want the profiles on the lines below, as \"  name:\"", path, n)
+			}
+			inProfiles, profileIndent, inList = true, 0, false
+			continue
+		}
+		if inProfiles {
+			if profileIndent == 0 {
+				profileIndent = indent
+			}
+			switch {
+			case indent < profileIndent:
+				return nil, fmt.Errorf("%s:%d: indented less than the profile names above it", path, n)
+			case indent == profileIndent:
+				if !inList || key == "" {
+					return nil, fmt.Errorf("%s:%d: want a profile name, as \"name:\", with its settings indented below it", path, n)
+				}
+				profile, inList = key, false
+				if _, ok := cf.profiles[profile]; !ok {
+					cf.profiles[profile] = nil
+				}
+				continue
+			}
+		}
+
+		e := configEntry{line: n, key: key}
+		if !inList {
+			v, err := yamlScalar(raw)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+			}
+			e.values = []string{v}
+		}
+		add(e)
+	}
+	return cf, sc.Err()
+}
+
+// yamlScalar decodes a plain, single-quoted or double-quoted YAML scalar. A
+// " #" starts a comment after a plain scalar.
+func yamlScalar(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		end := closingQuote(s)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		return strconv.Unquote(s[:end+1])
+	case strings.HasPrefix(s, "'"):
+		for i := 1; i < len(s); i++ {
+			if s[i] != '\'' {
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\'' {
+				i++
+				continue
+			}
+			return strings.ReplaceAll(s[1:i], "''", "'"), nil
+		}
+		return "", fmt.Errorf("unterminated string %s", s)
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s), nil
+}
+
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// quoteYAML renders v as a YAML scalar, quoting it only when a plain scalar
+// would be read back differently.
+func quoteYAML(v string) string {
+	if v == "" || strings.TrimSpace(v) != v || strings.ContainsAny(v[:1], "-?:,[]{}#&*!|>'\"%@`") ||
+		strings.Contains(v, ": ") || strings.Contains(v, " #") || strings.ContainsAny(v, "\n\t\\") {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+// applyConfigEntries sets every flag in entries, read from the config file
+// at path, that isn't already in set, and adds it to set.
+func applyConfigEntries(fs *flag.FlagSet, path string, entries []configEntry, set map[string]bool) []error {
+	var errs []error
+	for _, e := range entries {
+		f := fs.Lookup(e.key)
+		switch {
+		case f == nil:
+			errs = append(errs, fmt.Errorf("%s:%d: unknown setting %q", path, e.line, e.key))
+			continue
+		case fileOnlyFlags[e.key]:
+			errs = append(errs, fmt.Errorf("%s:%d: %s can't be set in a config file", path, e.line, e.key))
+			continue
+		case set[e.key]:
+			continue
+		}
+		set[e.key] = true
+
+		values := e.values
+		switch f.Value.(type) {
+		case *listFlag, *repeatFlag:
+		default:
+			// Lists for a plain flag become its comma-separated form.
+			values = []string{strings.Join(values, ",")}
+		}
+		for _, v := range values {
+			if err := f.Value.Set(v); err != nil {
+				errs = append(errs, fmt.Errorf("%s:%d: invalid %s: %v", path, e.line, e.key, err))
+			}
+		}
+	}
+	return errs
+}
+
+// writeEffectiveConfig renders every setting of fs as a poly.yaml that
+// reproduces it. Settings still at their defaults are written commented
+// out; each is preceded by its help text. profiles, the profiles: section
+// of the config file read, is written back as it was.
+func writeEffectiveConfig(out io.Writer, fs *flag.FlagSet, profiles map[string][]configEntry) {
+	fmt.Fprintln(out, "# Effective poly-watcher settings, written by 'poly-watcher --dump-config'.")
+	fmt.Fprintln(out, "# Every setting is a flag name; flags and POLY_* variables override it.")
+	fmt.Fprintln(out, "# Settings left at their defaults are commented out.")
+	if profile := fs.Lookup("profile").Value.String(); profile != "" {
+		fmt.Fprintf(out, "# Written with --profile %s, so its settings are included below.\n", profile)
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		if fileOnlyFlags[f.Name] {
+			return
+		}
+		fmt.Fprintln(out)
+		fmt.Fprintf(out, "# %s\n", f.Usage)
+		if f.Name == "http-auth-token" && f.Value.String() != "" {
+			fmt.Fprintf(out, "# %s: not written; set it with %s\n", f.Name, envName(f.Name))
+			return
+		}
+		prefix := ""
+		if f.Value.String() == f.DefValue {
+			prefix = "# "
+		}
+		if r, ok := f.Value.(*repeatFlag); ok && len(*r) > 0 {
+			writeConfigEntry(out, prefix, configEntry{key: f.Name, values: *r})
+			return
+		}
+		writeConfigEntry(out, prefix, configEntry{key: f.Name, values: []string{f.Value.String()}})
+	})
+	if len(profiles) == 0 {
+		return
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "profiles:")
+	for _, name := range slices.Sorted(maps.Keys(profiles)) {
+		fmt.Fprintf(out, "  %s:\n", name)
+		for _, e := range profiles[name] {
+			writeConfigEntry(out, "    ", e)
+		}
+	}
+}
+
+// writeConfigEntry writes e as a "key: value" line, or as a block list when
+// it doesn't have exactly one value, each line starting with prefix.
+func writeConfigEntry(out io.Writer, prefix string, e configEntry) {
+	if len(e.values) == 1 {
+		fmt.Fprintf(out, "%s%s: %s\n", prefix, e.key, quoteYAML(e.values[0]))
+		return
+	}
+	fmt.Fprintf(out, "%s%s:\n", prefix, e.key)
+	for _, v := range e.values {
+		fmt.Fprintf(out, "%s  - %s\n", prefix, quoteYAML(v))
+	}
+}
+
+// saveEffectiveConfig writes the effective settings, and the profiles of the
+// config file read, to path. It only replaces an existing file if that is
+// the config file this run read, whose settings it already includes, or
+// with --force.
+func (c *config) saveEffectiveConfig(fs *flag.FlagSet, path string) error {
+	var profiles map[string][]configEntry
+	if c.configFile != "" {
+		cf, err := parseConfigFile(c.configFile)
+		if err != nil {
+			return err
+		}
+		profiles = cf.profiles
+	}
+	if existing, err := os.Stat(path); err == nil && !c.force {
+		loaded, err := os.Stat(c.configFile)
+		if c.configFile == "" || err != nil || !os.SameFile(existing, loaded) {
+			return fmt.Errorf("%s already exists and wasn't read by this run; move it first or pass --force", path)
+		}
+	}
+	var b strings.Builder
+	writeEffectiveConfig(&b, fs, profiles)
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote the effective configuration to %s\n", path)
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadSettingsProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "poly.yaml")
+	config := `build: go build
+run: ./app
+check: go vet ./...
+exclude:
+  - vendor
+profiles:
+  debug:
+    run: dlv exec ./app
+    check: echo debug
+    exclude:
+      - vendor
+      - testdata
+  release:
+    build: go build -trimpath
+interval: 2s
+`
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "no profile",
+			want: map[string]string{"build": "go build", "run": "./app", "check": "go vet ./...", "exclude": "vendor", "interval": "2s"},
+		},
+		{
+			name: "file profile over the base settings",
+			args: []string{"--profile", "debug"},
+			want: map[string]string{"build": "go build", "run": "dlv exec ./app", "check": "echo debug", "exclude": "vendor,testdata", "interval": "2s"},
+		},
+		{
+			name: "file profile over the base environment",
+			args: []string{"--profile", "debug"},
+			env:  map[string]string{"POLY_RUN": "./env-app", "POLY_BUILD": "make"},
+			want: map[string]string{"build": "make", "run": "dlv exec ./app"},
+		},
+		{
+			name: "profile variables over the file profile",
+			env:  map[string]string{"POLY_PROFILE": "debug", "POLY_PROFILE_DEBUG_RUN": "./debug-app"},
+			want: map[string]string{"run": "./debug-app", "check": "echo debug"},
+		},
+		{
+			name: "flags over everything",
+			args: []string{"--profile", "debug", "--run", "./flag-app"},
+			env:  map[string]string{"POLY_PROFILE_DEBUG_RUN": "./debug-app"},
+			want: map[string]string{"run": "./flag-app", "check": "echo debug"},
+		},
+		{
+			name:    "unknown profile",
+			args:    []string{"--profile", "nope"},
+			want:    map[string]string{"run": "./app"},
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			for _, name := range []string{"build", "run", "check", "exclude", "profile", "config"} {
+				fs.String(name, "", "")
+			}
+			fs.Duration("interval", 0, "")
+			if err := fs.Parse(append([]string{"--config", path}, tt.args...)); err != nil {
+				t.Fatal(err)
+			}
+			set := make(map[string]bool)
+			fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+			errs := loadSettings(fs, set)
+			if gotErr := len(errs) > 0; gotErr != tt.wantErr {
+				t.Errorf("errors %v, want errors: %v", errs, tt.wantErr)
+			}
+			for name, want := range tt.want {
+				if got := fs.Lookup(name).Value.String(); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseConfigFileProfilesErrors(t *testing.T) {
+	for _, config := range []string{
+		"profiles: debug\n",
+		"profiles:\n  debug: x\n",
+		"profiles:\n    debug:\n      run: a\n  release:\n",
+		"profiles:\n  debug:\n  - run\n",
+	} {
+		path := filepath.Join(t.TempDir(), "poly.yaml")
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseConfigFile(path); err == nil {
+			t.Errorf("parseConfigFile accepted %q", config)
+		}
+	}
+}
+
+func TestSaveEffectiveConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "poly.yaml")
+	contents := `build: go build
+profiles:
+  debug:
+    run: dlv exec ./app
+    exclude:
+      - vendor
+      - testdata
+  release:
+    build: "go build -ldflags '-s -w'"
+`
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	for _, name := range []string{"build", "run", "exclude", "profile", "config"} {
+		fs.String(name, "", "")
+	}
+	if err := fs.Parse([]string{"--config", path, "--run", "./app"}); err != nil {
+		t.Fatal(err)
+	}
+	set := map[string]bool{"config": true, "run": true}
+	if errs := loadSettings(fs, set); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	before, err := parseConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &config{configFile: path}
+	if err := c.saveEffectiveConfig(fs, path); err != nil {
+		t.Fatalf("overwriting the config file read: %v", err)
+	}
+	after, err := parseConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string][]string)
+	for _, e := range after.entries {
+		got[e.key] = e.values
+	}
+	if want := map[string][]string{"build": {"go build"}, "run": {"./app"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("settings written = %q, want %q", got, want)
+	}
+	profiles := func(cf *configFile) map[string]map[string][]string {
+		m := make(map[string]map[string][]string)
+		for name, entries := range cf.profiles {
+			m[name] = make(map[string][]string)
+			for _, e := range entries {
+				m[name][e.key] = e.values
+			}
+		}
+		return m
+	}
+	if got, want := profiles(after), profiles(before); !reflect.DeepEqual(got, want) {
+		t.Errorf("profiles written = %q, want %q", got, want)
+	}
+
+	other := filepath.Join(dir, "other.yaml")
+	if err := os.WriteFile(other, []byte("build: make\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.saveEffectiveConfig(fs, other); err == nil {
+		t.Error("overwrote a config file this run didn't read")
+	}
+	c.force = true
+	if err := c.saveEffectiveConfig(fs, other); err != nil {
+		t.Errorf("with --force: %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io/fs"
+	"time"
+)
+
+// contentStamp is a file's content hash as of a given size, mtime and
+// inode change time.
+type contentStamp struct {
+	size           int64
+	modTime, ctime time.Time
+	sum            string
+}
+
+// fileContentSum returns the sha256 of file p in fsys, recorded as name, for
+// --hash-content. A file is only read again when its size, mtime or change
+// time moved, so a tree whose mtimes are untrustworthy (rsync without -t
+// bumps every file it touches; rsync -t and tar restore mtimes, at whole
+// seconds over older protocols) is still hashed by what it contains. The
+// change time is what catches an edit that kept the size and mtime.
+func (w *Watcher) fileContentSum(fsys fs.FS, p, name string, info fs.FileInfo) (string, error) {
+	stamp := contentStamp{size: info.Size(), modTime: info.ModTime(), ctime: changeTime(info)}
+	if c, ok := w.contentCache[name]; ok && c.size == stamp.size && c.modTime.Equal(stamp.modTime) && c.ctime.Equal(stamp.ctime) {
+		return c.sum, nil
+	}
+	sum, err := hashFileContent(fsys, p)
+	if err != nil {
+		return "", err
+	}
+	if w.contentCache == nil {
+		w.contentCache = make(map[string]contentStamp)
+	}
+	stamp.sum = sum
+	w.contentCache[name] = stamp
+	return sum, nil
+}
+
+// pruneContentCache forgets the files a scan no longer saw.
+func (w *Watcher) pruneContentCache(files map[string]fileState) {
+	for name := range w.contentCache {
+		if _, ok := files[name]; !ok {
+			delete(w.contentCache, name)
+		}
+	}
+}
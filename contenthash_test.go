@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHashContentRsync(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "config.yaml")
+	mtime := time.Unix(1_700_000_000, 0)
+	write := func(data string, mtime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(name, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("port: 8080\n", mtime)
+	if info, err := os.Stat(name); err != nil || changeTime(info).IsZero() {
+		t.Skip("no inode change time on this platform")
+	}
+
+	w := NewWatcher([]string{dir}, time.Second, "", "", "", "", nil, nil)
+	w.hashContent, w.hashMTime = true, false
+	prev, err := w.hashDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []struct {
+		name    string
+		data    string
+		mtime   time.Time
+		changed bool
+	}{
+		// rsync -t: new content of the same size, the sender's mtime kept
+		{"content rewritten, mtime kept", "port: 9090\n", mtime, true},
+		// rsync without -t: every file it touches gets a new mtime
+		{"mtime bumped, content kept", "port: 9090\n", mtime.Add(time.Hour), false},
+	} {
+		write(step.data, step.mtime)
+		scan, err := w.hashDir()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed := scan.hash != prev.hash; changed != step.changed {
+			t.Errorf("%s: hash changed = %v, want %v", step.name, changed, step.changed)
+		}
+		if n := diffFiles(prev.files, scan.files).count(); (n > 0) != step.changed {
+			t.Errorf("%s: diffFiles counts %d changes", step.name, n)
+		}
+		prev = scan
+	}
+
+	// Up to date with the file's stamp, the cache answers without a read
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.contentCache[name] = contentStamp{size: info.Size(), modTime: info.ModTime(), ctime: changeTime(info), sum: "cached"}
+	if sum, err := w.fileContentSum(os.DirFS(dir), "config.yaml", name, info); err != nil || sum != "cached" {
+		t.Errorf("fileContentSum = %q, %v; want the cached sum", sum, err)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// crashReport describes the last time an app process exited on its own with
+// an error, for GET /status.
+type crashReport struct {
+	App    string    `json:"app"`
+	Time   time.Time `json:"time"`
+	Error  string    `json:"error"`
+	Output []string  `json:"output,omitempty"` // last --crash-tail-lines lines
+	Note   string    `json:"note,omitempty"`
+}
+
+// recordCrash logs that an app process died with err, followed by the tail
+// of its output, and keeps the report for /status.
+func (w *Watcher) recordCrash(proc *appProcess, err error) {
+	report := &crashReport{App: proc.name, Time: time.Now(), Error: err.Error()}
+	if proc.tail != nil {
+		report.Output = proc.tail.get()
+	}
+	if proc.busyRetries > 0 {
+		report.Note = fmt.Sprintf("started after %d attempts that hit \"text file busy\"", proc.busyRetries)
+	}
+
+	if len(report.Output) == 0 {
+		log.Printf("%s crashed (%v)", appLabel(proc.name), err)
+	} else {
+		log.Printf("%s crashed (%v); last output:", appLabel(proc.name), err)
+		for _, line := range report.Output {
+			log.Printf("  | %s", line)
+		}
+	}
+	if report.Note != "" {
+		log.Printf("  (%s)", report.Note)
+	}
+	logAppExitHint(err)
+
+	w.processMu.Lock()
+	w.lastCrash = report
+	w.processMu.Unlock()
+}
+
+func (w *Watcher) lastCrashReport() *crashReport {
+	w.processMu.Lock()
+	defer w.processMu.Unlock()
+	return w.lastCrash
+}
+
+// appLabel is how logs refer to process name: plain "App" for a single run
+// command.
+func appLabel(name string) string {
+	if name == "app" {
+		return "App"
+	}
+	return "App " + name
+}
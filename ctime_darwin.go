@@ -0,0 +1,16 @@
+package main
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// changeTime returns the inode change time, which every write bumps and no
+// tool can set back, unlike the mtime rsync -t or tar restore.
+func changeTime(info fs.FileInfo) time.Time {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(st.Ctimespec.Unix())
+	}
+	return time.Time{}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// daemonChildEnv marks the re-executed background copy started by --daemon,
+// so it runs the watcher instead of detaching again.
+const daemonChildEnv = "POLY_WATCHER_DAEMON_CHILD"
+
+func isDaemonChild() bool {
+	return os.Getenv(daemonChildEnv) == "1"
+}
+
+func readPIDFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid pidfile %s", path)
+	}
+	return pid, nil
+}
+
+// removePIDFile deletes the pidfile if it still names this process, so a
+// daemon exiting late never removes its successor's pidfile.
+func removePIDFile(path string) {
+	if pid, err := readPIDFile(path); err == nil && pid == os.Getpid() {
+		os.Remove(path)
+	}
+}
+
+// daemonStatus prints whether the daemon named by the pidfile is running and
+// returns the exit code for --status: 0 if it is, 1 if not.
+func daemonStatus(pidFile string) int {
+	pid, err := readPIDFile(pidFile)
+	if err != nil {
+		fmt.Println("poly-watcher is not running")
+		return 1
+	}
+	if !processAlive(pid) {
+		fmt.Printf("poly-watcher is not running (stale pidfile %s names pid %d)\n", pidFile, pid)
+		return 1
+	}
+	fmt.Printf("poly-watcher is running (pid %d)\n", pid)
+	return 0
+}
@@ -0,0 +1,84 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// startDaemon re-executes poly-watcher in a new session, detached from the
+// terminal, with its logs and the build and app output appended to logFile.
+// The child's pid is written to pidFile before this returns.
+func startDaemon(pidFile, logFile string) error {
+	if pid, err := readPIDFile(pidFile); err == nil && processAlive(pid) {
+		return fmt.Errorf("already running (pid %d, see %s)", pid, pidFile)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	logf, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer logf.Close()
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		return err
+	}
+	defer devNull.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonChildEnv+"=1")
+	cmd.Stdin = devNull
+	cmd.Stdout = logf
+	cmd.Stderr = logf
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if err := os.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n", cmd.Process.Pid)), 0o644); err != nil {
+		_ = cmd.Process.Kill()
+		return err
+	}
+	fmt.Printf("poly-watcher started in the background (pid %d), logging to %s\n", cmd.Process.Pid, logFile)
+	return cmd.Process.Release()
+}
+
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// stopDaemon sends SIGTERM to the daemon named by pidFile and waits for it
+// to shut down.
+func stopDaemon(pidFile string) error {
+	pid, err := readPIDFile(pidFile)
+	if err != nil {
+		return fmt.Errorf("not running: %w", err)
+	}
+	if !processAlive(pid) {
+		os.Remove(pidFile)
+		return fmt.Errorf("not running (removed stale pidfile %s)", pidFile)
+	}
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		return err
+	}
+	// The watcher itself may take up to stopTimeout to wind down the app.
+	deadline := time.Now().Add(stopTimeout + 5*time.Second)
+	for processAlive(pid) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("pid %d did not exit after SIGTERM", pid)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	os.Remove(pidFile)
+	fmt.Printf("poly-watcher stopped (pid %d)\n", pid)
+	return nil
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// errNoDaemon explains the Windows alternative to --daemon.
+var errNoDaemon = errors.New("--daemon, --stop and --status are not supported on Windows; run poly-watcher under a service wrapper (e.g. NSSM or sc.exe) instead")
+
+func startDaemon(pidFile, logFile string) error {
+	return errNoDaemon
+}
+
+func processAlive(pid int) bool {
+	_, err := os.FindProcess(pid)
+	return err == nil
+}
+
+func stopDaemon(pidFile string) error {
+	return errNoDaemon
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// debounceRule sets the quiet window for changed files matching pattern, a
+// filepath.Match glob tried against both the file's path relative to its
+// root, as include and exclude rules see it, and its base name.
+type debounceRule struct {
+	pattern string
+	window  time.Duration
+}
+
+func parseDebounceRule(s string) (debounceRule, error) {
+	pattern, window, ok := strings.Cut(s, "=")
+	if !ok || pattern == "" {
+		return debounceRule{}, fmt.Errorf("invalid --debounce-rule %q, want glob=duration", s)
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return debounceRule{}, fmt.Errorf("invalid --debounce-rule %q: %v", s, err)
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil || d < 0 {
+		return debounceRule{}, fmt.Errorf("invalid --debounce-rule %q: bad duration", s)
+	}
+	return debounceRule{pattern: pattern, window: d}, nil
+}
+
+// matches reports whether the rule applies to relPath, relative to its root.
+func (r debounceRule) matches(relPath string) bool {
+	if ok, _ := filepath.Match(r.pattern, relPath); ok {
+		return true
+	}
+	ok, _ := filepath.Match(r.pattern, filepath.Base(relPath))
+	return ok
+}
+
+// debounceWindow returns how long the tree must stay quiet before building
+// for the given changes: the largest window of any changed file, where a file
+// takes the window of its first matching rule and --debounce otherwise. The
+// largest window wins so a mixed batch never builds before its slowest writer
+// (e.g. a bundler) has finished.
+func (w *Watcher) debounceWindow(c changeSet) time.Duration {
+	window := time.Duration(0)
+	for _, list := range [][]string{c.added, c.modified, c.deleted} {
+		for _, path := range list {
+			d := w.debounce
+			rel := w.relativeToRoot(path)
+			for _, r := range w.debounceRules {
+				if r.matches(rel) {
+					d = r.window
+					break
+				}
+			}
+			if d > window {
+				window = d
+			}
+		}
+	}
+	if w.largeChange(c) {
+		window = max(window, w.largeSettle)
+	}
+	return window
+}
+
+// settle waits until the tree has been unchanged for the debounce window of
+// everything that changed since the last build, and returns the final scan.
+// It returns ok=false if the watcher stopped while waiting. The initial build
+// is never debounced.
+func (w *Watcher) settle(scan scanResult) (scanResult, bool) {
+	if w.prevFiles == nil {
+		return scan, true
+	}
+	changes := diffFiles(w.prevFiles, scan.files)
+	window := w.debounceWindow(changes)
+	if window <= 0 {
+		return scan, true
+	}
+	if w.largeChange(changes) {
+		log.Printf("Large change detected (%d files), waiting %s for changes to settle...", changes.count(), window)
+	} else {
+		log.Printf("Change detected, waiting %s for changes to settle...", window)
+	}
+	for {
+		if !w.sleep(window) {
+			return scan, false
+		}
+		next, err := w.hashDir()
+		if err != nil {
+			log.Println("Error hashing dir:", err)
+			return scan, true
+		}
+		if next.hash == scan.hash {
+			return next, true
+		}
+		scan = next
+		window = w.debounceWindow(diffFiles(w.prevFiles, scan.files))
+	}
+}
+
+// settleStartup holds the initial build until the tree has been unchanged
+// for --settle-time, so a checkout or sync still in progress when the
+// watcher starts doesn't set off a burst of builds. It reports false if the
+// watcher was stopped while waiting.
+func (w *Watcher) settleStartup(scan scanResult) (scanResult, bool) {
+	if w.settleTime <= 0 {
+		return scan, true
+	}
+	log.Printf("Waiting for tree to settle (%s without changes)...", w.settleTime)
+	for {
+		if !w.sleep(w.settleTime) {
+			return scan, false
+		}
+		next, err := w.hashDir()
+		if err != nil {
+			log.Println("Error hashing dir:", err)
+			return scan, true
+		}
+		if next.hash == scan.hash {
+			return next, true
+		}
+		w.debugf("Tree still changing, waiting again")
+		scan = next
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// contentSum is a file's content hash as of a given size and mtime.
+type contentSum struct {
+	st  fileState
+	sum string
+}
+
+// sameContent reports whether c only rewrote files with the content they
+// had at the last change, as editors that save by writing a temp file and
+// renaming it over the original (vim's writebackup dance, for one) can
+// make one save show up as two changes. Every modified file is hashed and
+// remembered for next time; a file seen modified for the first time counts
+// as changed, since its earlier content is unknown.
+func (w *Watcher) sameContent(c changeSet, files map[string]fileState) bool {
+	if w.contentSums == nil {
+		w.contentSums = make(map[string]contentSum)
+	}
+	same := len(c.added) == 0 && len(c.deleted) == 0 && len(c.modified) > 0
+	for _, name := range c.modified {
+		st := files[name]
+		prev, known := w.contentSums[name]
+		if known && prev.st.size == st.size && prev.st.modTime.Equal(st.modTime) {
+			continue
+		}
+		sum, err := hashFileContent(os.DirFS(filepath.Dir(name)), filepath.Base(name))
+		if err != nil {
+			delete(w.contentSums, name)
+			same = false
+			continue
+		}
+		if !known || prev.sum != sum {
+			same = false
+		}
+		w.contentSums[name] = contentSum{st: st, sum: sum}
+	}
+	for _, name := range c.deleted {
+		delete(w.contentSums, name)
+	}
+	if same {
+		log.Printf("Ignoring %s: contents unchanged (--dedupe-saves)", c.summary())
+	}
+	return same
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// saveByRename saves data to name the way vim's writebackup does: into a
+// new file renamed over the original, so the inode changes.
+func saveByRename(t *testing.T, name, data string, mtime time.Time) {
+	t.Helper()
+	tmp := name + "~"
+	if err := os.WriteFile(tmp, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(tmp, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSameContentWriteRename(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "main.go")
+	base := time.Unix(1_700_000_000, 0)
+	saveByRename(t, name, "package main\n", base)
+
+	w := NewWatcher([]string{dir}, time.Second, "", "", "", "", nil, nil)
+	prev, err := w.hashDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, step := range []struct {
+		data string
+		same bool
+	}{
+		{"package main\n\nfunc main() {}\n", false}, // an edit, and its earlier content is unknown
+		{"package main\n\nfunc main() {}\n", true},  // saved again unchanged
+		{"package main\n\nfunc main() {}\n", true},
+		{"package main\n", false}, // back to the original
+	} {
+		before, _ := os.Stat(name)
+		saveByRename(t, name, step.data, base.Add(time.Duration(i+1)*time.Second))
+		after, _ := os.Stat(name)
+		if os.SameFile(before, after) {
+			t.Fatal("rename kept the inode")
+		}
+
+		scan, err := w.hashDir()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := diffFiles(prev.files, scan.files)
+		if len(c.modified) != 1 || c.count() != 1 {
+			t.Fatalf("step %d: diffFiles = %+v, want main.go modified", i, c)
+		}
+		if got := w.sameContent(c, scan.files); got != step.same {
+			t.Errorf("step %d: sameContent = %v, want %v", i, got, step.same)
+		}
+		prev = scan
+	}
+}
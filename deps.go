@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// depRule runs command before the build whenever a file named like file
+// (matched by base name anywhere in the tree) changes content.
+type depRule struct {
+	file    string
+	command string
+}
+
+func parseDepRule(s string) (depRule, error) {
+	file, command, ok := strings.Cut(s, "=")
+	file, command = strings.TrimSpace(file), strings.TrimSpace(command)
+	if !ok || file == "" || command == "" {
+		return depRule{}, fmt.Errorf("invalid --dep %q, want file=command", s)
+	}
+	return depRule{file: file, command: command}, nil
+}
+
+// depTracker decides whether a dependency file really changed by comparing
+// content hashes, so a rewrite that only bumps the mtime doesn't rerun the
+// dep command. Files are only re-read when their mtime moves.
+type depTracker struct {
+	mtimes    map[string]time.Time
+	rules     map[string]int    // index of the rule each file belongs to
+	current   map[string]string // content hash as of the last read
+	committed map[string]string // content hash the dep command last succeeded for
+}
+
+func hashFileContent(fsys fs.FS, path string) (string, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// trackDepFile records the content of dep file path in fsys, recorded as
+// name, for rule.
+func (w *Watcher) trackDepFile(rule int, fsys fs.FS, path, name string, info fs.FileInfo) {
+	d := &w.deps
+	if d.mtimes == nil {
+		d.mtimes = make(map[string]time.Time)
+		d.rules = make(map[string]int)
+		d.current = make(map[string]string)
+	}
+	d.rules[name] = rule
+	if mtime, ok := d.mtimes[name]; ok && mtime.Equal(info.ModTime()) {
+		return
+	}
+	sum, err := hashFileContent(fsys, path)
+	if err != nil {
+		w.walkErrors.logf("Error reading %s: %v", name, err)
+		return
+	}
+	d.mtimes[name] = info.ModTime()
+	d.current[name] = sum
+}
+
+// changedDeps returns, for each dep rule, the files whose content differs
+// from what its command last ran against.
+func (w *Watcher) changedDeps() map[int][]string {
+	d := &w.deps
+	changed := make(map[int][]string)
+	for name, sum := range d.current {
+		if sum != d.committed[name] {
+			rule := d.rules[name]
+			changed[rule] = append(changed[rule], name)
+		}
+	}
+	return changed
+}
+
+// commitDeps records the current contents of files as handled and persists
+// them to the state file, if one is configured.
+func (w *Watcher) commitDeps(files []string) {
+	d := &w.deps
+	if d.committed == nil {
+		d.committed = make(map[string]string)
+	}
+	for _, name := range files {
+		d.committed[name] = d.current[name]
+	}
+	w.saveState()
+}
+
+// runDeps runs, in rule order, the command of every dep rule whose files
+// changed, each at most once. A failing command aborts the build; its files
+// stay pending so it runs again next time.
+func (w *Watcher) runDeps() error {
+	files, err := w.runDepCommands(w.changedDeps())
+	w.finishDeps(files)
+	return err
+}
+
+// runDepCommands runs the dep commands for changed, returning the files of
+// those that succeeded. It leaves the watcher's state alone so it can run in
+// the background; finishDeps records the result.
+func (w *Watcher) runDepCommands(changed map[int][]string) ([]string, error) {
+	var done []string
+	for i, rule := range w.depRules {
+		files, ok := changed[i]
+		if !ok {
+			continue
+		}
+		log.Printf("%s changed: running %s...\n", strings.Join(files, ", "), rule.command)
+		if err := w.runShell(rule.command); err != nil {
+			return done, fmt.Errorf("dependency command for %s failed: %w", filepath.Base(rule.file), err)
+		}
+		done = append(done, files...)
+	}
+	return done, nil
+}
+
+// finishDeps commits the files whose dep commands succeeded and has the next
+// scan pick up what they wrote.
+func (w *Watcher) finishDeps(files []string) {
+	if len(files) == 0 {
+		return
+	}
+	w.commitDeps(files)
+	w.watchSet.stale = true
+}
+
+// runDepsAsync starts the dep commands in the background for --async-deps and
+// returns a barrier that blocks until they have finished and reports their
+// error. Only the commands run in the background: the barrier records their
+// result, so it must be called from the run loop.
+func (w *Watcher) runDepsAsync() func() error {
+	changed := w.changedDeps()
+	if len(changed) == 0 {
+		return func() error { return nil }
+	}
+	log.Printf("Running dep commands in the background until the %s step needs them...\n", w.asyncDeps)
+	type result struct {
+		files []string
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		files, err := w.runDepCommands(changed)
+		done <- result{files, err}
+	}()
+	return sync.OnceValue(func() error {
+		var r result
+		select {
+		case r = <-done:
+		default:
+			log.Println("Waiting for dep commands to finish...")
+			r = <-done
+		}
+		w.finishDeps(r.files)
+		return r.err
+	})
+}
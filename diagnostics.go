@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sync/atomic"
+)
+
+// The default --error-pattern and --warning-pattern cover the "error:" and
+// "warning:" lines of gcc, clang, rustc, javac, tsc and most linters, where
+// the word starts the line or follows a file:line: location, and eslint's
+// table. Go's own errors are located at ./file.go:line:col: (vet's type
+// errors with a "vet: " prefix); vet and linter findings, at a bare
+// file.go:line:col:, count as warnings.
+const (
+	defaultErrorPattern   = `(?i)(^\s*|:\d+(:\d+)?: |\(\d+,\d+\): | - )(fatal )?error(\[[\w-]+\]| TS\d+)?:|^\s+\d+:\d+\s+error\s|^(vet: )?(\.{1,2}/|/)\S*\.go:\d+:\d+: `
+	defaultWarningPattern = `(?i)(^\s*|:\d+(:\d+)?: |\(\d+,\d+\): | - )warning(\[[\w-]+\]| TS\d+)?:|^\s+\d+:\d+\s+warning\s|^[\w-][^\s:]*\.go:\d+:\d+: `
+)
+
+// defaultDiagSummary matches the totals compilers print after their
+// diagnostics, which the default patterns would count once more.
+var defaultDiagSummary = regexp.MustCompile(`(?i)\d+ (warnings?|errors?) (generated|emitted)|aborting due to|previous errors?`)
+
+// diagCounter counts the errors and warnings in the current build's output,
+// across every command the build runs.
+type diagCounter struct {
+	errorPattern   *regexp.Regexp
+	warningPattern *regexp.Regexp
+	skip           *regexp.Regexp // nil once either pattern is overridden
+
+	errors   atomic.Int64
+	warnings atomic.Int64
+}
+
+func newDiagCounter(errorPattern, warningPattern string) *diagCounter {
+	d := &diagCounter{skip: defaultDiagSummary}
+	if errorPattern == "" {
+		errorPattern = defaultErrorPattern
+	} else {
+		d.skip = nil
+	}
+	if warningPattern == "" {
+		warningPattern = defaultWarningPattern
+	} else {
+		d.skip = nil
+	}
+	d.errorPattern = regexp.MustCompile(errorPattern)
+	d.warningPattern = regexp.MustCompile(warningPattern)
+	return d
+}
+
+func (d *diagCounter) line(line string) {
+	if d.skip != nil && d.skip.MatchString(line) {
+		return
+	}
+	if d.errorPattern.MatchString(line) {
+		d.errors.Add(1)
+	} else if d.warningPattern.MatchString(line) {
+		d.warnings.Add(1)
+	}
+}
+
+func (d *diagCounter) reset() {
+	d.errors.Store(0)
+	d.warnings.Store(0)
+}
+
+// counts returns the errors and warnings of the running or last build.
+func (d *diagCounter) counts() (int, int) {
+	return int(d.errors.Load()), int(d.warnings.Load())
+}
+
+// enforceMaxWarnings fails an otherwise successful build whose output had
+// more than --max-warnings warnings, and logs the counts.
+func (w *Watcher) enforceMaxWarnings(err error) error {
+	errs, warnings := w.diags.counts()
+	if errs+warnings > 0 {
+		log.Printf("Build output had %d errors and %d warnings", errs, warnings)
+	}
+	if err != nil && !errors.Is(err, errNoRestart) || w.maxWarnings < 0 || warnings <= w.maxWarnings {
+		return err
+	}
+	return inStage("build", fmt.Errorf("%d warnings, more than --max-warnings %d", warnings, w.maxWarnings))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDefaultDiagPatterns(t *testing.T) {
+	for _, tt := range []struct {
+		line           string
+		errors, warned int
+	}{
+		{"main.c:3:5: error: expected ';' before '}' token", 1, 0},
+		{"main.c:1:10: fatal error: foo.h: No such file or directory", 1, 0},
+		{"main.c:7:9: warning: unused variable 'x' [-Wunused-variable]", 0, 1},
+		{"error[E0308]: mismatched types", 1, 0},
+		{"warning: unused variable: `x`", 0, 1},
+		{"error: aborting due to 2 previous errors", 0, 0},
+		{"1 warning generated.", 0, 0},
+		{"Foo.java:3: error: cannot find symbol", 1, 0},
+		{"src/app.ts(3,5): error TS2322: Type 'string' is not assignable to type 'number'.", 1, 0},
+		{"src/app.ts:3:5 - error TS2322: Type 'string' is not assignable to type 'number'.", 1, 0},
+		{"  3:5  error  'x' is not defined  no-undef", 1, 0},
+		{"  4:1  warning  Unexpected console statement  no-console", 0, 1},
+		{"./main.go:2:15: undefined: x", 1, 0},
+		{"../lib/util.go:8:2: imported and not used: \"os\"", 1, 0},
+		{"vet: ./main.go:2:15: undefined: x", 1, 0},
+		// go vet and linter findings
+		{"main.go:3:27: fmt.Printf format %d has arg \"x\" of wrong type string", 0, 1},
+		{"internal/db/conn.go:40:2: SA4006: this value of err is never used (staticcheck)", 0, 1},
+		// Not diagnostics
+		{"ok: no error: all fine", 0, 0},
+		{"retrying after a warning: from the proxy cache", 0, 0},
+		{"SyntaxError handling enabled", 0, 0},
+		{"# example.com/app", 0, 0},
+		{"main_test.go:12: got 1, want 2", 0, 0},
+	} {
+		d := newDiagCounter("", "")
+		d.line(tt.line)
+		if errs, warnings := d.counts(); errs != tt.errors || warnings != tt.warned {
+			t.Errorf("%q: %d errors, %d warnings; want %d, %d", tt.line, errs, warnings, tt.errors, tt.warned)
+		}
+	}
+}
+
+func TestMaxWarningsAcrossRetries(t *testing.T) {
+	dir := t.TempDir()
+	// Ten warnings each time, and only the first attempt fails
+	build := "for i in 1 2 3 4 5 6 7 8 9 10; do echo \"x.c:$i:1: warning: unused\"; done; test -e " + dir + "/retried || { touch " + dir + "/retried; exit 1; }"
+	w := NewWatcher([]string{dir}, time.Second, build, "", "", "", nil, nil)
+	w.buildRetries, w.buildRetryBackoff = 1, time.Millisecond
+	w.maxWarnings = 15
+
+	if err := w.enforceMaxWarnings(w.runBuildWithRetries()); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if _, warnings := w.diags.counts(); warnings != 10 {
+		t.Errorf("%d warnings, want the last attempt's 10", warnings)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/fs"
+	"path"
+	"time"
+)
+
+// racyListing is how long after a directory's mtime a listing of it stays
+// untrusted: an entry added in the same mtime tick as the listing (1s on
+// HFS+ and ext3, 2s on FAT) wouldn't move the mtime again.
+const racyListing = 2 * time.Second
+
+// dirListing is a directory's entries as read at one scan.
+type dirListing struct {
+	modTime time.Time
+	readAt  time.Time
+	entries []fs.DirEntry
+}
+
+// prescanFS serves ReadDir from the previous scan's listing while the
+// directory's mtime is unchanged, for --dir-mtime-prescan. Adding, removing
+// or renaming an entry bumps its directory's mtime; editing a file in place
+// doesn't, so the entries it returns still stat their file on every Info.
+type prescanFS struct {
+	fs.FS
+	prev, next     map[string]dirListing
+	reused, listed int
+}
+
+func (p *prescanFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	info, err := fs.Stat(p.FS, name)
+	if err != nil {
+		return nil, err
+	}
+	if l, ok := p.prev[name]; ok && l.modTime.Equal(info.ModTime()) && l.readAt.Sub(l.modTime) > racyListing {
+		p.next[name] = l
+		p.reused++
+		return l.entries, nil
+	}
+
+	readAt := time.Now()
+	entries, err := fs.ReadDir(p.FS, name)
+	if err != nil {
+		return entries, err
+	}
+	for i, e := range entries {
+		entries[i] = freshEntry{DirEntry: e, fsys: p.FS, path: path.Join(name, e.Name())}
+	}
+	p.next[name] = dirListing{modTime: info.ModTime(), readAt: readAt, entries: entries}
+	p.listed++
+	return entries, nil
+}
+
+// freshEntry is a cached directory entry whose Info reads the file's
+// current size and mtime rather than the ones from when it was listed.
+type freshEntry struct {
+	fs.DirEntry
+	fsys fs.FS
+	path string
+}
+
+func (e freshEntry) Info() (fs.FileInfo, error) {
+	// fs.Stat follows symlinks, so a link keeps the listing's own
+	// (re-read on Linux) info
+	if e.Type()&fs.ModeSymlink != 0 {
+		return e.DirEntry.Info()
+	}
+	return fs.Stat(e.fsys, e.path)
+}
+
+// prescanned wraps root's filesystem for --dir-mtime-prescan; done stores its
+// listings for the next scan, dropping the ones of directories that weren't
+// walked this time.
+func (w *Watcher) prescanned(root string, fsys fs.FS) (_ fs.FS, done func()) {
+	if w.dirListings == nil {
+		w.dirListings = make(map[string]map[string]dirListing)
+	}
+	p := &prescanFS{FS: fsys, prev: w.dirListings[root], next: make(map[string]dirListing)}
+	return p, func() {
+		w.dirListings[root] = p.next
+		if p.listed > 0 {
+			w.debugf("Prescan of %s: %d directories unchanged, %d listed", root, p.reused, p.listed)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// EventType identifies what happened in the watcher.
+type EventType string
+
+const (
+	BaselineReady  EventType = "baseline_ready"
+	ChangeDetected EventType = "change_detected"
+	BuildStarted   EventType = "build_started"
+	BuildFinished  EventType = "build_finished"
+	AppStarted     EventType = "app_started"
+	AppReady       EventType = "app_ready"
+	AppExited      EventType = "app_exited"
+)
+
+// Event is delivered on the channel returned by Watcher.Events.
+type Event struct {
+	Type    EventType
+	Time    time.Time
+	Files   int    // number of watched files, for BaselineReady
+	Changes int    // number of changed files, for ChangeDetected
+	Err     error  // build or app error, for BuildFinished and AppExited
+	App     string // process name, for AppStarted and AppExited with several run commands
+
+	Errors   int // error and warning lines in the build's output, for BuildFinished
+	Warnings int
+}
+
+// MarshalJSON renders the event for the HTTP event stream.
+func (ev Event) MarshalJSON() ([]byte, error) {
+	out := struct {
+		Type     EventType `json:"type"`
+		Time     time.Time `json:"time"`
+		Files    int       `json:"files,omitempty"`
+		Changes  int       `json:"changes,omitempty"`
+		Error    string    `json:"error,omitempty"`
+		App      string    `json:"app,omitempty"`
+		Errors   int       `json:"errors,omitempty"`
+		Warnings int       `json:"warnings,omitempty"`
+	}{Type: ev.Type, Time: ev.Time, Files: ev.Files, Changes: ev.Changes, App: ev.App, Errors: ev.Errors, Warnings: ev.Warnings}
+	if ev.Err != nil {
+		out.Error = ev.Err.Error()
+	}
+	return json.Marshal(out)
+}
+
+const defaultEventBuffer = 64
+
+// eventStream fans watcher events into a buffered channel. When the consumer
+// falls behind, the oldest buffered event is dropped to make room.
+type eventStream struct {
+	mu     sync.Mutex
+	ch     chan Event
+	buffer int
+	closed bool
+}
+
+// Events returns a channel of watcher events. The channel is created on the
+// first call, holds up to the configured buffer, and is closed when Run
+// returns. Events are only recorded once Events has been called.
+func (w *Watcher) Events() <-chan Event {
+	s := &w.events
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ch == nil {
+		size := s.buffer
+		if size <= 0 {
+			size = defaultEventBuffer
+		}
+		s.ch = make(chan Event, size)
+	}
+	return s.ch
+}
+
+func (w *Watcher) emit(ev Event) {
+	ev.Time = time.Now()
+	w.eventHub.publish(ev)
+
+	s := &w.events
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ch == nil || s.closed {
+		return
+	}
+	for {
+		select {
+		case s.ch <- ev:
+			return
+		default:
+		}
+		select {
+		case <-s.ch:
+		default:
+		}
+	}
+}
+
+func (w *Watcher) closeEvents() {
+	s := &w.events
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ch != nil && !s.closed {
+		close(s.ch)
+	}
+	s.closed = true
+}
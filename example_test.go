@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func ExampleWatcher_Events() {
+	dir, err := os.MkdirTemp("", "poly-watcher-example")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0o644); err != nil {
+		log.Fatal(err)
+	}
+
+	w := NewWatcher([]string{dir}, 100*time.Millisecond, "true", "sleep 60", "", "", nil, nil)
+	events := w.Events()
+	go w.Run()
+	defer w.Stop()
+
+	// The first scan builds and starts the app
+	for ev := range events {
+		fmt.Println(ev.Type)
+		if ev.Type == AppStarted {
+			break
+		}
+	}
+	// Output:
+	// baseline_ready
+	// change_detected
+	// build_started
+	// build_finished
+	// app_started
+}
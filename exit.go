@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// Reasons returned by Run. Each maps to the CLI exit code noted beside it;
+// any other error exits with 1.
+var (
+	// ErrStopped means Stop was called, e.g. on SIGINT or SIGTERM. (exit 0)
+	ErrStopped = errors.New("watcher stopped")
+	// ErrInvalidConfig means the settings failed validation. (exit 2)
+	ErrInvalidConfig = errors.New("invalid configuration")
+	// ErrIdle means no change was detected for --max-idle. (exit 3)
+	ErrIdle = errors.New("idle timeout reached")
+	// ErrMaxRuntime means the watcher ran for --max-runtime. (exit 4)
+	ErrMaxRuntime = errors.New("maximum runtime reached")
+	// ErrInitialScan means the first scan of the roots failed, e.g. because
+	// a root is unreadable. Later scan errors are retried. (exit 5)
+	ErrInitialScan = errors.New("initial scan failed")
+	// ErrBuildFailed means a build failed with --fail-fast, after any
+	// --build-retries. (exit: the build's own exit code, or 8 if it didn't
+	// get to exit, e.g. on --build-timeout)
+	ErrBuildFailed = errors.New("build failed")
+)
+
+var exitCodes = []struct {
+	err  error
+	code int
+}{
+	{ErrStopped, 0},
+	{ErrInvalidConfig, 2},
+	{ErrIdle, 3},
+	{ErrMaxRuntime, 4},
+	{ErrInitialScan, 5},
+	{ErrManifestDrift, 6},
+	{ErrUncleanExit, 7},
+	{ErrBuildFailed, 8},
+}
+
+// buildFailedError is the ErrBuildFailed returned by Run, carrying the exit
+// code of the failed build, 0 when it didn't get to exit.
+type buildFailedError struct {
+	code int
+	err  error
+}
+
+func newBuildFailedError(err error) *buildFailedError {
+	e := &buildFailedError{err: err}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		e.code = exitErr.ExitCode()
+	}
+	return e
+}
+
+func (e *buildFailedError) Error() string   { return ErrBuildFailed.Error() + ": " + e.err.Error() }
+func (e *buildFailedError) Unwrap() []error { return []error{ErrBuildFailed, e.err} }
+
+// exitCode maps the error returned by Run to the process exit code.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var bf *buildFailedError
+	if errors.As(err, &bf) && bf.code > 0 {
+		return bf.code
+	}
+	for _, ec := range exitCodes {
+		if errors.Is(err, ec.err) {
+			return ec.code
+		}
+	}
+	return 1
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// unreadableFS fails every open, as a root without read permission does.
+type unreadableFS struct{}
+
+func (unreadableFS) Open(name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+}
+
+func TestRunUnreadableRoot(t *testing.T) {
+	w := NewWatcher([]string{"src"}, time.Second, "", "", "", "", nil, nil)
+	w.openFS = func(string) fs.FS { return unreadableFS{} }
+
+	err := w.Run()
+	if !errors.Is(err, ErrInitialScan) {
+		t.Fatalf("Run returned %v, want ErrInitialScan", err)
+	}
+	if code := exitCode(err); code != 5 {
+		t.Errorf("exitCode(%v) = %d, want 5", err, code)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	buildErr := exec.Command("/bin/sh", "-c", "exit 42").Run()
+	for _, tt := range []struct {
+		err  error
+		code int
+	}{
+		{nil, 0},
+		{ErrStopped, 0},
+		{fmt.Errorf("%w: %v", ErrInitialScan, fs.ErrPermission), 5},
+		{newBuildFailedError(inStage("build", buildErr)), 42},
+		// Killed on --build-timeout, so there's no exit code to pass through
+		{newBuildFailedError(inStage("build", errors.New("build timed out after 1m0s"))), 8},
+		{fmt.Errorf("%w: %w", ErrBuildFailed, buildErr), 8},
+		{errors.New("opening --app-stdout: permission denied"), 1},
+	} {
+		if code := exitCode(tt.err); code != tt.code {
+			t.Errorf("exitCode(%v) = %d, want %d", tt.err, code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+)
+
+// maxExplainedFiles bounds the files GET /explain lists, so a branch switch
+// doesn't make every rebuild expensive to record.
+const maxExplainedFiles = 200
+
+// rebuildExplanation is the GET /explain body: why the most recent rebuild
+// ran and what came of it. It is recorded as the rebuild runs, from what
+// the watcher decided at the time, so serving it costs nothing.
+type rebuildExplanation struct {
+	Time       time.Time       `json:"time"`
+	Trigger    string          `json:"trigger"` // as in GET /history
+	Cause      string          `json:"cause"`
+	Files      []explainedFile `json:"files,omitempty"`
+	FilesTotal int             `json:"files_total,omitempty"` // when more than the listed files changed
+	Deps       []explainedDep  `json:"deps,omitempty"`
+	Action     string          `json:"action"` // see rebuild
+	Error      string          `json:"error,omitempty"`
+}
+
+// explainedFile is a changed file and the rules it matched.
+type explainedFile struct {
+	Path     string   `json:"path"`
+	Change   string   `json:"change"`            // added, modified or deleted
+	Include  string   `json:"include,omitempty"` // the --include that admitted it, if there are any
+	Scopes   []string `json:"scopes,omitempty"`  // the actions with a --scope it concerns
+	ReloadOn bool     `json:"reload_on,omitempty"`
+}
+
+// explainedDep is a dep file whose command the rebuild was to run.
+type explainedDep struct {
+	File    string `json:"file"`
+	Command string `json:"command"`
+}
+
+var triggerCauses = map[string]string{
+	"change":       "watched files changed",
+	"trigger-file": "--trigger-file was touched",
+	"git":          "git HEAD moved",
+	"manual":       "a rebuild was requested (POST /rebuild or a signal)",
+	"resume":       "files changed while the watcher was paused",
+	"start":        "the run target didn't exist for --skip-initial-build",
+	"build-lock":   "retrying a build skipped for --build-lock",
+	"source":       "--change-source-cmd reported changes",
+	"quiet-end":    "a --quiet-schedule window ended with changes pending",
+}
+
+// explainRebuild starts the record of a rebuild set off by trigger.
+// Without changes of its own, e.g. for a manual rebuild, the files changed
+// since the last successful build are listed.
+func (w *Watcher) explainRebuild(trigger string, changes changeSet) *rebuildExplanation {
+	ex := &rebuildExplanation{Time: time.Now(), Trigger: trigger, Cause: triggerCauses[trigger]}
+	if changes.count() > 0 {
+		ex.Cause = changes.summary()
+	} else if w.builtFiles != nil {
+		changes = diffFiles(w.builtFiles, w.prevFiles)
+	}
+	for kind, list := range map[string][]string{"added": changes.added, "modified": changes.modified, "deleted": changes.deleted} {
+		for _, name := range list {
+			ex.Files = append(ex.Files, w.explainFile(name, kind))
+		}
+	}
+	sort.Slice(ex.Files, func(i, j int) bool { return ex.Files[i].Path < ex.Files[j].Path })
+	if len(ex.Files) > maxExplainedFiles {
+		ex.FilesTotal = len(ex.Files)
+		ex.Files = ex.Files[:maxExplainedFiles]
+	}
+
+	for rule, files := range w.changedDeps() {
+		for _, name := range files {
+			ex.Deps = append(ex.Deps, explainedDep{File: name, Command: w.depRules[rule].command})
+		}
+	}
+	sort.Slice(ex.Deps, func(i, j int) bool { return ex.Deps[i].File < ex.Deps[j].File })
+	for i, dep := range ex.Deps {
+		sep := ", "
+		if i == 0 {
+			sep = "; dep files changed: "
+		}
+		ex.Cause += sep + dep.File
+	}
+	return ex
+}
+
+func (w *Watcher) explainFile(name, kind string) explainedFile {
+	rel := w.relativeToRoot(name)
+	f := explainedFile{Path: name, Change: kind}
+	for _, in := range w.includes {
+		if matchRule(in, rel) {
+			f.Include = in
+			break
+		}
+	}
+	for action, scope := range w.scopes {
+		if scope.matches(rel) {
+			f.Scopes = append(f.Scopes, action)
+		}
+	}
+	slices.Sort(f.Scopes)
+	f.ReloadOn = slices.ContainsFunc(w.reloadOn, func(rule string) bool { return matchRule(rule, rel) })
+	return f
+}
+
+func (w *Watcher) handleExplain(rw http.ResponseWriter, r *http.Request) {
+	ex := w.lastExplain.Load()
+	if ex == nil {
+		http.Error(rw, "no rebuild yet", http.StatusNotFound)
+		return
+	}
+	writeJSON(rw, ex)
+}
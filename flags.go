@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// listFlag is a string list flag that accepts comma-separated values and may
+// be repeated, e.g. --root=./api,./web --root=../shared.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// repeatFlag is a string list flag that may be repeated. Unlike listFlag it
+// never splits on commas, so values can be shell commands.
+type repeatFlag []string
+
+// String quotes each value, so --run 'a b' and --run a --run b differ in
+// summaries and fingerprints.
+func (r *repeatFlag) String() string {
+	if len(*r) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%q", []string(*r))
+}
+
+func (r *repeatFlag) Set(value string) error {
+	*r = append(*r, value)
+	return nil
+}
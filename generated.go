@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"regexp"
+	"time"
+)
+
+// defaultContentExcludeBytes is how much of each file --exclude-content
+// looks at: enough for the usual "Code generated ... DO NOT EDIT." header.
+const defaultContentExcludeBytes = 1024
+
+// contentMark caches whether a file's header matched --exclude-content, valid
+// while its size and mtime are unchanged.
+type contentMark struct {
+	size    int64
+	modTime time.Time
+	matched bool
+}
+
+// contentExcluded reports whether the first contentExcludeBytes of the file
+// match the --exclude-content pattern. Files are only re-read when their size
+// or mtime changes; unreadable files are never excluded.
+func (w *Watcher) contentExcluded(fsys fs.FS, path, name string, info fs.FileInfo) bool {
+	if m, ok := w.contentMarks[name]; ok && m.size == info.Size() && m.modTime.Equal(info.ModTime()) {
+		return m.matched
+	}
+	matched, err := headerMatches(fsys, path, w.contentExclude, w.contentExcludeBytes)
+	if err != nil {
+		w.walkErrors.logf("Error reading %s: %v", name, err)
+		return false
+	}
+	if w.contentMarks == nil {
+		w.contentMarks = make(map[string]contentMark)
+	}
+	w.contentMarks[name] = contentMark{size: info.Size(), modTime: info.ModTime(), matched: matched}
+	return matched
+}
+
+func headerMatches(fsys fs.FS, path string, re *regexp.Regexp, n int) (bool, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	buf := make([]byte, n)
+	read, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	return re.Match(buf[:read]), nil
+}
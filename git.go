@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// gitDir returns the git directory of root, following the "gitdir:" file a
+// worktree or submodule has in place of a .git directory.
+func gitDir(root string) (string, bool) {
+	dotGit := filepath.Join(root, ".git")
+	info, err := os.Stat(dotGit)
+	if err != nil {
+		return "", false
+	}
+	if info.IsDir() {
+		return dotGit, true
+	}
+	data, err := os.ReadFile(dotGit)
+	if err != nil {
+		return "", false
+	}
+	dir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+	if !ok {
+		return "", false
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+	return dir, true
+}
+
+// gitHead describes where HEAD points: the ref (empty when detached) and the
+// commit it resolves to.
+func gitHead(dir string) string {
+	data, err := os.ReadFile(filepath.Join(dir, "HEAD"))
+	if err != nil {
+		return ""
+	}
+	head := strings.TrimSpace(string(data))
+	ref, ok := strings.CutPrefix(head, "ref: ")
+	if !ok {
+		return head // detached
+	}
+	return ref + " " + resolveRef(dir, ref)
+}
+
+// resolveRef reads ref from its loose file, falling back to packed-refs. For
+// a worktree, branch refs live in the common directory.
+func resolveRef(dir, ref string) string {
+	dirs := []string{dir}
+	if data, err := os.ReadFile(filepath.Join(dir, "commondir")); err == nil {
+		common := strings.TrimSpace(string(data))
+		if !filepath.IsAbs(common) {
+			common = filepath.Join(dir, common)
+		}
+		dirs = append(dirs, common)
+	}
+	for _, d := range dirs {
+		if data, err := os.ReadFile(filepath.Join(d, filepath.FromSlash(ref))); err == nil {
+			return strings.TrimSpace(string(data))
+		}
+	}
+	for _, d := range dirs {
+		f, err := os.Open(filepath.Join(d, "packed-refs"))
+		if err != nil {
+			continue
+		}
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			if sha, name, ok := strings.Cut(sc.Text(), " "); ok && name == ref {
+				f.Close()
+				return sha
+			}
+		}
+		f.Close()
+	}
+	return ""
+}
+
+// gitHeadMoved reports whether HEAD of any root's repository moved (a
+// checkout, commit, reset or rebase) since the last check. The first check
+// only records a baseline.
+func (w *Watcher) gitHeadMoved() bool {
+	if !w.watchGit {
+		return false
+	}
+	var heads []string
+	for _, root := range w.roots {
+		if dir, ok := gitDir(root); ok {
+			heads = append(heads, gitHead(dir))
+		}
+	}
+	head := strings.Join(heads, "\n")
+	moved := w.gitHeadSeen && head != w.gitHeadState
+	w.gitHeadState = head
+	w.gitHeadSeen = true
+	return moved
+}
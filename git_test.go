@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGitHeadMovedWhilePaused(t *testing.T) {
+	root := t.TempDir()
+	ref := filepath.Join(root, ".git", "refs", "heads", "main")
+	if err := os.MkdirAll(filepath.Dir(ref), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{
+		filepath.Join(root, ".git", "HEAD"): "ref: refs/heads/main\n",
+		ref:                                 "1111111\n",
+		filepath.Join(root, "main.go"):      "package main\n",
+	} {
+		if err := os.WriteFile(name, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := NewWatcher([]string{root}, 20*time.Millisecond, "true", "sleep 60", "", "", nil, nil)
+	w.watchGit = true
+
+	events := w.Events()
+	done := make(chan error, 1)
+	go func() { done <- w.Run() }()
+	defer func() {
+		w.Stop()
+		<-done
+	}()
+	next := func(want EventType) bool {
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case ev := <-events:
+				if ev.Type == want {
+					return true
+				}
+			case <-timeout:
+				return false
+			}
+		}
+	}
+	if !next(AppStarted) {
+		t.Fatal("app never started")
+	}
+
+	w.enqueue(triggerPause)
+	time.Sleep(100 * time.Millisecond)
+	// A commit while paused
+	if err := os.WriteFile(ref, []byte("2222222\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	for len(events) > 0 {
+		if ev := <-events; ev.Type == BuildStarted {
+			t.Fatal("rebuilt while paused")
+		}
+	}
+	w.enqueue(triggerResume)
+	if !next(BuildStarted) {
+		t.Fatal("no rebuild after resuming")
+	}
+}
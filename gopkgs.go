@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// goPackagesEnv names the variable --go-incremental passes to the build
+// command, e.g. --build 'go test $POLY_GO_PACKAGES'.
+const goPackagesEnv = "POLY_GO_PACKAGES"
+
+// goGraph maps the module's package directories to import paths and each
+// package to the module packages that depend on it, directly or not. It is
+// reloaded when go.mod changes or a changed file is in an unknown directory.
+type goGraph struct {
+	modHash string
+	byDir   map[string]string   // absolute dir -> import path
+	rdeps   map[string][]string // import path -> dependent import paths
+}
+
+// goModRoot is the first root with a go.mod, where go list runs.
+func (w *Watcher) goModRoot() (string, bool) {
+	for _, root := range w.roots {
+		if _, err := os.Stat(filepath.Join(root, "go.mod")); err == nil {
+			return root, true
+		}
+	}
+	return "", false
+}
+
+// loadGoGraph lists the module's packages under root. A package's tests count
+// as depending on it too: they only report their direct imports, so what
+// those depend on is added as well.
+func loadGoGraph(root string) (*goGraph, error) {
+	cmd := exec.Command("go", "list", "-e", "-json=ImportPath,Dir,Deps,TestImports,XTestImports", "./...")
+	cmd.Dir = root
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list: %w", err)
+	}
+
+	type listedPackage struct {
+		ImportPath   string
+		Dir          string
+		Deps         []string
+		TestImports  []string
+		XTestImports []string
+	}
+	var pkgs []listedPackage
+	deps := make(map[string][]string)
+	dec := json.NewDecoder(strings.NewReader(string(out)))
+	for {
+		var pkg listedPackage
+		if err := dec.Decode(&pkg); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("go list: %w", err)
+		}
+		pkgs = append(pkgs, pkg)
+		deps[pkg.ImportPath] = pkg.Deps
+	}
+
+	g := &goGraph{byDir: make(map[string]string), rdeps: make(map[string][]string)}
+	for _, pkg := range pkgs {
+		g.byDir[pkg.Dir] = pkg.ImportPath
+		seen := map[string]bool{pkg.ImportPath: true}
+		add := func(dep string) {
+			if !seen[dep] {
+				seen[dep] = true
+				g.rdeps[dep] = append(g.rdeps[dep], pkg.ImportPath)
+			}
+		}
+		for _, dep := range pkg.Deps {
+			add(dep)
+		}
+		for _, imp := range slices.Concat(pkg.TestImports, pkg.XTestImports) {
+			add(imp)
+			for _, dep := range deps[imp] {
+				add(dep)
+			}
+		}
+	}
+	return g, nil
+}
+
+// goGraphFor returns the cached graph for root, reloading it when go.mod's
+// contents changed or force is set.
+func (w *Watcher) goGraphFor(root string, force bool) (*goGraph, error) {
+	modHash, err := hashFileContent(os.DirFS(root), "go.mod")
+	if err != nil {
+		return nil, err
+	}
+	if w.goGraph != nil && w.goGraph.modHash == modHash && !force {
+		return w.goGraph, nil
+	}
+	g, err := loadGoGraph(root)
+	if err != nil {
+		return nil, err
+	}
+	g.modHash = modHash
+	w.goGraph = g
+	return g, nil
+}
+
+// affectedGoPackages returns the packages containing changed .go files plus
+// every module package that depends on them, sorted. It returns nil when the
+// set can't be narrowed down (no Go files changed, a changed file's package
+// is unknown, or go list failed), meaning everything should be built.
+func (w *Watcher) affectedGoPackages(c changeSet) []string {
+	root, ok := w.goModRoot()
+	if !ok {
+		return nil
+	}
+	var dirs []string
+	for _, list := range [][]string{c.added, c.modified, c.deleted} {
+		for _, name := range list {
+			if strings.HasSuffix(name, ".go") {
+				dir, err := filepath.Abs(filepath.Dir(name))
+				if err != nil {
+					return nil
+				}
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	if len(dirs) == 0 {
+		return nil
+	}
+
+	g, err := w.goGraphFor(root, false)
+	if err != nil {
+		log.Println("Go package graph unavailable, building everything:", err)
+		return nil
+	}
+	affected := make(map[string]bool)
+	for _, dir := range dirs {
+		pkg, ok := g.byDir[dir]
+		if !ok {
+			// A new package, or one that's gone: reload once
+			if g, err = w.goGraphFor(root, true); err != nil {
+				return nil
+			}
+			if pkg, ok = g.byDir[dir]; !ok {
+				return nil
+			}
+		}
+		affected[pkg] = true
+		for _, dep := range g.rdeps[pkg] {
+			affected[dep] = true
+		}
+	}
+	return sortedKeys(affected)
+}
+
+// goBuildEnv is the extra build environment for --go-incremental: the
+// packages affected since the last successful build, or ./... for all.
+func (w *Watcher) goBuildEnv() []string {
+	if !w.goIncremental {
+		return nil
+	}
+	var pkgs []string
+	if w.builtFiles != nil && !w.fullRebuild {
+		pkgs = w.affectedGoPackages(diffFiles(w.builtFiles, w.prevFiles))
+	}
+	if len(pkgs) == 0 {
+		w.debugf("Building all Go packages")
+		return []string{goPackagesEnv + "=./..."}
+	}
+	log.Printf("Go packages affected: %d", len(pkgs))
+	w.debugf("Affected packages: %s", strings.Join(pkgs, " "))
+	return []string{goPackagesEnv + "=" + strings.Join(pkgs, " ")}
+}
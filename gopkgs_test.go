@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestAffectedGoPackagesTestImporters(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not in PATH")
+	}
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"go.mod":      "module example.com/m\n\ngo 1.21\n",
+		"a/a.go":      "package a\n\nfunc A() {}\n",
+		"b/b.go":      "package b\n",
+		"b/b_test.go": "package b\n\nimport (\n\t\"testing\"\n\n\t\"example.com/m/c\"\n)\n\nfunc TestB(t *testing.T) { c.C() }\n",
+		"c/c.go":      "package c\n\nimport \"example.com/m/a\"\n\nfunc C() { a.A() }\n",
+		"d/d.go":      "package d\n",
+		"d/x_test.go": "package d_test\n\nimport (\n\t\"testing\"\n\n\t\"example.com/m/a\"\n)\n\nfunc TestD(t *testing.T) { a.A() }\n",
+		"e/e.go":      "package e\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := NewWatcher([]string{dir}, time.Second, "", "", "", "", nil, nil)
+
+	got := w.affectedGoPackages(changeSet{modified: []string{filepath.Join(dir, "a", "a.go")}})
+	// b's tests import c, which imports a; d's external tests import a
+	want := []string{"example.com/m/a", "example.com/m/b", "example.com/m/c", "example.com/m/d"}
+	if !slices.Equal(got, want) {
+		t.Errorf("affectedGoPackages = %q, want %q", got, want)
+	}
+}
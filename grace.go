@@ -0,0 +1,41 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// inRestartGrace reports whether the app started less than
+// --post-restart-grace ago.
+func (w *Watcher) inRestartGrace() bool {
+	started := w.appStartedAt.Load()
+	return w.restartGrace > 0 && started != 0 && time.Since(time.Unix(0, started)) < w.restartGrace
+}
+
+// graceAllows reports whether c touches a file matching
+// --post-restart-grace-allow, which rebuilds even within the grace window.
+func (w *Watcher) graceAllows(c changeSet) bool {
+	for _, list := range [][]string{c.added, c.modified, c.deleted} {
+		for _, name := range list {
+			rel := w.relativeToRoot(name)
+			for _, rule := range w.graceAllow {
+				if matchRule(rule, rel) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// suppressedByGrace reports whether changes are the app's own startup
+// writes (PID files, caches, logs) landing within --post-restart-grace,
+// which only move the baseline instead of rebuilding.
+func (w *Watcher) suppressedByGrace(c changeSet) bool {
+	if !w.inRestartGrace() || w.graceAllows(c) {
+		return false
+	}
+	log.Printf("Ignoring %s within --post-restart-grace of the app starting", c.summary())
+	w.logChanges(c)
+	return true
+}
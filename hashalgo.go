@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"sort"
+	"strings"
+)
+
+// hashAlgorithms are the digests available for the tree hash. The hash only
+// covers file metadata, so even sha256 costs little next to the walk itself.
+//
+//   - fnv64: the original algorithm; fastest, but with 64 bits the birthday
+//     bound makes a missed rebuild conceivable over very long sessions on
+//     huge trees.
+//   - fnv128: nearly as fast with a 128-bit state; collisions are not a
+//     practical concern (default).
+//   - sha256: cryptographic strength, for when the digest must be trusted.
+var hashAlgorithms = map[string]func() hash.Hash{
+	"fnv64":  func() hash.Hash { return fnv.New64a() },
+	"fnv128": func() hash.Hash { return fnv.New128a() },
+	"sha256": sha256.New,
+}
+
+const defaultHashAlgo = "fnv128"
+
+func hashAlgoNames() string {
+	names := make([]string, 0, len(hashAlgorithms))
+	for name := range hashAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func newHashFunc(name string) (func() hash.Hash, error) {
+	fn, ok := hashAlgorithms[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash algorithm %q (available: %s)", name, hashAlgoNames())
+	}
+	return fn, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// hashComponents are the parts of a file --hash-include can fold into the
+// tree hash. The default, path,size,mtime, catches every save; sensible
+// alternatives are:
+//
+//   - path,size,content: ignore mtime entirely, for trees touched by tools
+//     that bump it (the same as --hash-content)
+//   - path,size: rebuild only when a file grows or shrinks, cheap but blind
+//     to same-size edits
+//   - path: rebuild only when files are added, removed or renamed
+//
+// path can't be left out: files are told apart by it. Nor can mtime and
+// content go together: content stands in for mtime, so an edit that only
+// touches a file is what it is there to ignore.
+var hashComponents = []string{"path", "size", "mtime", "content", "mode"}
+
+func parseHashInclude(s string) ([]string, error) {
+	var parts []string
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !slices.Contains(hashComponents, part) {
+			return nil, fmt.Errorf("invalid --hash-include component %q, want some of %s", part, strings.Join(hashComponents, ", "))
+		}
+		parts = append(parts, part)
+	}
+	if !slices.Contains(parts, "path") {
+		return nil, fmt.Errorf("--hash-include must include path")
+	}
+	if slices.Contains(parts, "mtime") && slices.Contains(parts, "content") {
+		return nil, fmt.Errorf("--hash-include can't have both mtime and content; content replaces mtime")
+	}
+	return parts, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// checkHealth performs a single GET against the health URL. Any transport
+// error or non-2xx status counts as a failure.
+func (w *Watcher) checkHealth(client *http.Client) error {
+	resp, err := client.Get(w.healthURL)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// healthLoop polls the app's health endpoint while it is running and has the
+// run loop restart it (without rebuilding) after healthFailures consecutive
+// failed checks.
+// Checks are skipped while a rebuild or restart is in progress.
+func (w *Watcher) healthLoop() {
+	client := &http.Client{Timeout: w.healthInterval}
+	// Its keep-alive connections would outlive the watcher otherwise
+	defer client.CloseIdleConnections()
+	failures := 0
+	healthy := true
+
+	for w.sleep(w.healthInterval) {
+		if w.cycling.Load() || !w.appRunning() {
+			failures = 0
+			continue
+		}
+
+		if err := w.checkHealth(client); err != nil {
+			failures++
+			if healthy {
+				log.Printf("Health check failed: %v", err)
+				healthy = false
+			}
+			if failures >= w.healthFailures {
+				log.Printf("App unhealthy after %d consecutive failures, restarting...", failures)
+				failures = 0
+				// The run loop restarts it, with --wait-for and readiness
+				// checks, and never in the middle of a build
+				w.enqueue(triggerRestart)
+			}
+			continue
+		}
+
+		failures = 0
+		if !healthy {
+			log.Println("App is healthy again")
+			healthy = true
+		}
+	}
+}
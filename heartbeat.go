@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// buildElapsed returns how long the current build has been running, or 0 if
+// no build is in progress.
+func (w *Watcher) buildElapsed() time.Duration {
+	start := w.buildStart.Load()
+	if start == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, start))
+}
+
+// startHeartbeat marks a build as running and, with --build-heartbeat, logs
+// its elapsed time every interval until the returned func is called. Quick
+// builds finish before the first tick and log nothing.
+func (w *Watcher) startHeartbeat() (stop func()) {
+	start := time.Now()
+	w.buildStart.Store(start.UnixNano())
+	done := make(chan struct{})
+	if w.buildHeartbeat > 0 {
+		go func() {
+			ticker := time.NewTicker(w.buildHeartbeat)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					log.Printf("Build still running... %s elapsed", time.Since(start).Round(time.Second))
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	return func() {
+		close(done)
+		w.buildStart.Store(0)
+	}
+}
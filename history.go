@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// buildCycle is one rebuild as kept for GET /history.
+type buildCycle struct {
+	Time     time.Time `json:"time"`
+	Trigger  string    `json:"trigger"` // change, trigger-file, git, manual, resume or build-lock
+	Changes  int       `json:"changes,omitempty"`
+	Duration string    `json:"duration"`
+	Result   string    `json:"result"`              // ok, failed or no-restart
+	Stage    string    `json:"stage,omitempty"`     // the failed stage: deps, check or build
+	ExitCode *int      `json:"exit_code,omitempty"` // unset when the build didn't get to exit
+	Error    string    `json:"error,omitempty"`
+}
+
+// cycleHistory keeps the last --history-size build cycles.
+type cycleHistory struct {
+	mu     sync.Mutex
+	max    int
+	cycles []buildCycle
+}
+
+func (h *cycleHistory) add(c buildCycle) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.max <= 0 {
+		return
+	}
+	if len(h.cycles) == h.max {
+		h.cycles = h.cycles[1:]
+	}
+	h.cycles = append(h.cycles, c)
+}
+
+// list returns the kept cycles, oldest first.
+func (h *cycleHistory) list() []buildCycle {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]buildCycle, len(h.cycles))
+	copy(out, h.cycles)
+	return out
+}
+
+// recordCycle adds a finished build to the history.
+func (w *Watcher) recordCycle(trigger string, changes int, start time.Time, err error) {
+	c := buildCycle{
+		Time:     start,
+		Trigger:  trigger,
+		Changes:  changes,
+		Duration: time.Since(start).Round(time.Millisecond).String(),
+		Result:   "ok",
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(err, errNoRestart):
+		c.Result = "no-restart"
+		c.ExitCode = &w.noRestartCode
+	case err == nil:
+		code := 0
+		c.ExitCode = &code
+	case errors.As(err, &exitErr):
+		code := exitErr.ExitCode()
+		c.ExitCode = &code
+		fallthrough
+	default:
+		c.Result = "failed"
+		c.Stage = failedStage(err)
+		c.Error = err.Error()
+	}
+	w.history.add(c)
+}
+
+// printHistory implements --history: it fetches GET /history from the
+// watcher at addr and prints it as a table.
+func printHistory(addr string) error {
+	if addr == "" {
+		return fmt.Errorf("no watcher address; pass --http with the address the watcher's --http server listens on")
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	resp, err := http.Get(strings.TrimSuffix(addr, "/") + "/history")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET /history: %s", resp.Status)
+	}
+	var cycles []buildCycle
+	if err := json.NewDecoder(resp.Body).Decode(&cycles); err != nil {
+		return fmt.Errorf("GET /history: %v", err)
+	}
+	if len(cycles) == 0 {
+		fmt.Println("No builds yet")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tTRIGGER\tCHANGES\tDURATION\tRESULT\tEXIT")
+	failed := 0
+	for _, c := range cycles {
+		exit := "-"
+		if c.ExitCode != nil {
+			exit = strconv.Itoa(*c.ExitCode)
+		}
+		result := c.Result
+		if c.Result == "failed" {
+			failed++
+			if c.Stage != "" {
+				result += " (" + c.Stage + ")"
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", c.Time.Local().Format("15:04:05"), c.Trigger, c.Changes, c.Duration, result, exit)
+	}
+	tw.Flush()
+	fmt.Printf("%d builds, %d failed\n", len(cycles), failed)
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import "sync"
+
+// hub fans values out to any number of subscribers. Publishing never blocks:
+// a subscriber whose buffer is full misses the value.
+type hub[T any] struct {
+	mu   sync.Mutex
+	subs map[chan T]struct{}
+}
+
+func (h *hub[T]) subscribe(buffer int) chan T {
+	ch := make(chan T, buffer)
+	h.mu.Lock()
+	if h.subs == nil {
+		h.subs = make(map[chan T]struct{})
+	}
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *hub[T]) unsubscribe(ch chan T) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+}
+
+func (h *hub[T]) publish(v T) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- v:
+		default:
+		}
+	}
+}
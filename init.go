@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// projectTemplate is the starter config for one kind of project, detected by
+// the presence of marker in the working directory.
+type projectTemplate struct {
+	name     string
+	marker   string
+	build    string
+	run      string
+	includes string
+	excludes string
+	deps     []string
+}
+
+// projectTemplates are checked in order; the first whose marker exists wins.
+var projectTemplates = []projectTemplate{
+	{
+		name: "Go", marker: "go.mod",
+		build: "go build -o ./bin/app .", run: "./bin/app",
+		includes: ".go,go.mod,go.sum", excludes: "bin,vendor",
+		deps: []string{"go.mod=go mod download"},
+	},
+	{
+		name: "Rust", marker: "Cargo.toml",
+		build: "cargo build", run: "cargo run --quiet",
+		includes: ".rs,Cargo.toml,Cargo.lock", excludes: "target",
+		deps: []string{"Cargo.toml=cargo fetch"},
+	},
+	{
+		name: "Node.js", marker: "package.json",
+		build: "npm run build --if-present", run: "npm start",
+		includes: ".js,.ts,.json", excludes: "node_modules,dist,coverage",
+		deps: []string{"package.json=npm install"},
+	},
+	{
+		name: "Python", marker: "pyproject.toml",
+		build: "python -m compileall -q .", run: "python -m app",
+		includes: ".py,pyproject.toml", excludes: "__pycache__,venv",
+		deps: []string{"pyproject.toml=pip install -e ."},
+	},
+	{
+		name: "Python", marker: "requirements.txt",
+		build: "python -m compileall -q .", run: "python main.py",
+		includes: ".py,requirements.txt", excludes: "__pycache__,venv",
+		deps: []string{"requirements.txt=pip install -r requirements.txt"},
+	},
+	{
+		name: "Make", marker: "Makefile",
+		build: "make", run: "make run",
+	},
+}
+
+// genericTemplate is used when no marker is found.
+var genericTemplate = projectTemplate{
+	name:  "generic",
+	build: "echo 'TODO: set the build command'",
+	run:   "echo 'TODO: set the run command'",
+}
+
+func detectProject() projectTemplate {
+	for _, t := range projectTemplates {
+		if _, err := os.Stat(t.marker); err == nil {
+			return t
+		}
+	}
+	return genericTemplate
+}
+
+// writeStarterConfig renders t as a commented poly.yaml.
+func writeStarterConfig(out io.Writer, t projectTemplate) {
+	fmt.Fprintf(out, "# poly-watcher config for a %s project, written by 'poly-watcher init'.\n", t.name)
+	fmt.Fprintln(out, "# Every setting is a flag name; flags and POLY_* variables override it.")
+	fmt.Fprintln(out, "# See 'poly-watcher --help' for the full list.")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "# Run after a file changes; a failed build leaves the app running.")
+	fmt.Fprintf(out, "build: %s\n", quoteYAML(t.build))
+	fmt.Fprintln(out, "# Started after every successful build, stopped before the next.")
+	fmt.Fprintf(out, "run: %s\n", quoteYAML(t.run))
+	if len(t.deps) > 0 {
+		fmt.Fprintln(out)
+		fmt.Fprintln(out, "# Run before the build when the dependency file's contents change.")
+		fmt.Fprintln(out, "dep:")
+		for _, d := range t.deps {
+			fmt.Fprintf(out, "  - %s\n", quoteYAML(d))
+		}
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "# Name rules (no '/') match a file or directory name anywhere;")
+	fmt.Fprintln(out, "# rules starting with '.' match the end of a file name.")
+	if t.includes != "" {
+		fmt.Fprintf(out, "include: %s\n", quoteYAML(t.includes))
+	} else {
+		fmt.Fprintln(out, "# include: .go,.html")
+	}
+	if t.excludes != "" {
+		fmt.Fprintf(out, "exclude: %s\n", quoteYAML(t.excludes))
+	} else {
+		fmt.Fprintln(out, "# exclude: tmp,build")
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "interval: 1s")
+	fmt.Fprintln(out, "# debounce: 200ms")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "# Named profiles, picked with --profile NAME, override the settings above.")
+	fmt.Fprintln(out, "# profiles:")
+	fmt.Fprintln(out, "#   debug:")
+	fmt.Fprintln(out, "#     run-wrapper: dlv exec --headless --listen=:2345 --")
+}
+
+// runInit implements `poly-watcher init [--force] [--dry-run]`.
+func runInit(args []string) error {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	path := fs.String("config", defaultConfigFile, "File to write")
+	force := fs.Bool("force", false, "Overwrite an existing config file")
+	dryRun := fs.Bool("dry-run", false, "Print the config instead of writing it")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: poly-watcher init [--force] [--dry-run] [--config file]")
+		fmt.Fprintln(fs.Output(), "Detects the project type in the current directory and writes a starter config.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	t := detectProject()
+	if *dryRun {
+		writeStarterConfig(os.Stdout, t)
+		return nil
+	}
+	if _, err := os.Stat(*path); err == nil && !*force {
+		return fmt.Errorf("%s already exists; use --force to overwrite it", *path)
+	}
+
+	var b strings.Builder
+	writeStarterConfig(&b, t)
+	if err := os.WriteFile(*path, []byte(b.String()), 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("Detected a %s project; wrote %s\n", t.name, *path)
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// signalNames are the signals --build-kill-signal and --run-stop-signal
+// accept, with or without the SIG prefix.
+var signalNames = map[string]syscall.Signal{
+	"HUP": syscall.SIGHUP, "INT": syscall.SIGINT, "QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL, "TERM": syscall.SIGTERM,
+}
+
+func signalName(sig syscall.Signal) string {
+	for name, s := range signalNames {
+		if s == sig {
+			return name
+		}
+	}
+	return sig.String()
+}
+
+func parseSignal(name string) (syscall.Signal, error) {
+	sig, ok := signalNames[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("unknown signal %q (want HUP, INT, QUIT, KILL or TERM)", name)
+	}
+	return sig, nil
+}
+
+// buildKillGrace is how long a timed-out build gets to exit after a
+// --build-kill-signal other than KILL before it is killed anyway.
+const buildKillGrace = 5 * time.Second
+
+// signalStep is one rung of a stop ladder: send sig, then give the process
+// wait to exit before the next rung.
+type signalStep struct {
+	sig  syscall.Signal
+	wait time.Duration
+}
+
+// parseLadder parses a --build-kill-ladder or --run-stop-ladder such as
+// "TERM:10s,INT:5s". SIGKILL always follows the last step, so a trailing
+// KILL may be given or left out.
+func parseLadder(s string) ([]signalStep, error) {
+	steps := []signalStep{}
+	parts := strings.Split(s, ",")
+	for i, part := range parts {
+		name, wait, hasWait := strings.Cut(strings.TrimSpace(part), ":")
+		sig, err := parseSignal(name)
+		if err != nil {
+			return nil, err
+		}
+		if sig == syscall.SIGKILL {
+			if i != len(parts)-1 || hasWait {
+				return nil, fmt.Errorf("KILL can only be the last step, without a wait")
+			}
+			break
+		}
+		d, err := time.ParseDuration(wait)
+		if !hasWait || err != nil || d <= 0 {
+			return nil, fmt.Errorf("step %q needs a positive wait, as in TERM:10s", part)
+		}
+		steps = append(steps, signalStep{sig: sig, wait: d})
+	}
+	return steps, nil
+}
+
+// buildSteps is the ladder for a build past --build-timeout: --build-kill-ladder,
+// or --build-kill-signal with a 5s grace.
+func (w *Watcher) buildSteps() []signalStep {
+	if w.buildLadder != nil {
+		return w.buildLadder
+	}
+	if w.buildKillSignal == syscall.SIGKILL {
+		return nil
+	}
+	return []signalStep{{sig: w.buildKillSignal, wait: buildKillGrace}}
+}
+
+// stopSteps is the ladder for stopping the app: --run-stop-ladder, or
+// --run-stop-signal for --drain-timeout.
+func (w *Watcher) stopSteps() []signalStep {
+	if w.stopLadder != nil {
+		return w.stopLadder
+	}
+	if w.drainTimeout <= 0 {
+		return nil
+	}
+	return []signalStep{{sig: w.runStopSignal, wait: w.drainTimeout}}
+}
+
+// escalate walks steps, sending each signal and waiting for exited to report
+// the process gone, then sends SIGKILL. Each step is logged for what, e.g.
+// "build". It reports whether the process exited before SIGKILL.
+func escalate(what string, steps []signalStep, send func(syscall.Signal), exited func(time.Duration) bool) bool {
+	for i, st := range steps {
+		if i == 0 {
+			log.Printf("Sending SIG%s to the %s, waiting up to %s for it to exit", signalName(st.sig), what, st.wait)
+		} else {
+			log.Printf("%s still running after SIG%s, sending SIG%s and waiting up to %s", capitalize(what), signalName(steps[i-1].sig), signalName(st.sig), st.wait)
+		}
+		send(st.sig)
+		if exited(st.wait) {
+			return true
+		}
+	}
+	if len(steps) > 0 {
+		log.Printf("%s still running after SIG%s, sending SIGKILL", capitalize(what), signalName(steps[len(steps)-1].sig))
+	}
+	send(syscall.SIGKILL)
+	return false
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// runBuildProcess runs a build command in its own process group, under
+// --build-nice and --build-memory-limit. After --build-timeout, or when the
+// watcher stops, the whole group goes through the build's stop ladder, so a
+// hung compiler a shell script started can't outlive the build. Being in its
+// own group, the build doesn't get the terminal's Ctrl-C itself.
+func (w *Watcher) runBuildProcess(cmd *exec.Cmd) error {
+	setProcessGroup(cmd)
+	if w.buildMemory > 0 {
+		// Every build runs as /bin/sh -c script
+		cmd.Args[len(cmd.Args)-1] = memoryLimited(cmd.Args[len(cmd.Args)-1], w.buildMemory)
+	}
+	niced(cmd, w.buildNice)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	var timeout <-chan time.Time
+	if w.buildTimeout > 0 {
+		timeout = time.After(w.buildTimeout)
+	}
+	var err error
+	select {
+	case err := <-done:
+		return err
+	case <-timeout:
+		log.Printf("Build still running after --build-timeout of %s", w.buildTimeout)
+		err = fmt.Errorf("build timed out after %s", w.buildTimeout)
+	case <-w.stopCh:
+		log.Println("Stopping the running build...")
+		err = errors.New("build stopped")
+	}
+
+	exited := escalate("build", w.buildSteps(), func(sig syscall.Signal) { _ = signalGroup(cmd, sig) }, func(d time.Duration) bool {
+		select {
+		case <-done:
+			return true
+		case <-time.After(d):
+			return false
+		}
+	})
+	if exited {
+		// The shell exited, but children that ignored the signals are
+		// still in its group
+		_ = signalGroup(cmd, syscall.SIGKILL)
+	} else {
+		<-done
+	}
+	return err
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// largeChange reports whether c is more than --large-change-threshold
+// files, like a branch switch or a code generator rewriting a tree. Such a
+// batch waits out --large-change-settle and gets a full rebuild: --clean
+// first, then every build step and app regardless of --scope, all Go
+// packages with --go-incremental, and never just a --reload-signal. The
+// first build, with nothing built to compare to, is never a large change.
+func (w *Watcher) largeChange(c changeSet) bool {
+	return w.largeThreshold > 0 && w.builtFiles != nil && c.count() > w.largeThreshold
+}
+
+// runClean runs --clean before a full rebuild.
+func (w *Watcher) runClean() error {
+	if w.cleanCmd == "" {
+		return nil
+	}
+	log.Println("Running clean command...")
+	if err := w.runShell(w.cleanCmd); err != nil {
+		return inStage("clean", fmt.Errorf("clean failed, skipping build: %w", err))
+	}
+	return nil
+}
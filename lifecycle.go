@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os/exec"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// stopTimeout bounds how long Stop waits for the run loop and the app to
+// shut down.
+const stopTimeout = 10 * time.Second
+
+// Stop ends the run loop and the app. It blocks until Run has returned or
+// stopTimeout elapses, and is safe to call more than once.
+func (w *Watcher) Stop() error {
+	w.stopOnce.Do(func() { close(w.stopCh) })
+	if !w.started.Load() {
+		return w.stopApp()
+	}
+	select {
+	case <-w.done:
+		return nil
+	case <-time.After(stopTimeout):
+		return errors.New("timed out waiting for watcher to stop")
+	}
+}
+
+func (w *Watcher) stopped() bool {
+	select {
+	case <-w.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleep waits for d and reports whether the watcher is still running.
+func (w *Watcher) sleep(d time.Duration) bool {
+	select {
+	case <-w.stopCh:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// stopContext returns a context that is done after timeout, or as soon as the
+// watcher stops.
+func (w *Watcher) stopContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	go func() {
+		select {
+		case <-w.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// groupCommand runs a shell command in its own process group, all of which
+// is killed once ctx is done, so a hung pipeline can't outlive its deadline
+// by way of a child the shell left behind.
+func groupCommand(ctx context.Context, command string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error { return signalGroup(cmd, syscall.SIGKILL) }
+	// Don't wait on output pipes a killed child may still be holding
+	cmd.WaitDelay = time.Second
+	return cmd
+}
+
+// stopApp stops the running app, if any, and waits for it to exit.
+func (w *Watcher) stopApp() error {
+	w.processMu.Lock()
+	defer w.processMu.Unlock()
+	if len(w.processes) == 0 {
+		return nil
+	}
+	log.Println("Stopping app...")
+	return w.stopProcessLocked()
+}
+
+// stopProcessLocked stops every app process; the caller holds processMu.
+func (w *Watcher) stopProcessLocked() error {
+	return w.stopProcessesLocked(slices.Clone(w.processes))
+}
+
+// stopProcessesLocked stops procs; the caller holds processMu. The
+// --pre-stop hook runs once first (e.g. to fail a load balancer health
+// check). The processes then go through the app's stop ladder, each step
+// giving them time to finish in-flight work and exit on their own, before
+// they are killed.
+func (w *Watcher) stopProcessesLocked(procs []*appProcess) error {
+	for _, p := range procs {
+		p.stopping.Store(true)
+	}
+
+	if w.preStop != "" {
+		log.Println("Running pre-stop hook...")
+		if err := w.runShell(w.preStop); err != nil {
+			log.Println("Pre-stop hook failed:", err)
+		}
+	}
+
+	send := func(sig syscall.Signal) {
+		for _, p := range procs {
+			p.signal(sig)
+		}
+	}
+	var err error
+	if escalate("app", w.stopSteps(), send, func(d time.Duration) bool { return waitExited(procs, d) }) {
+		// Reap anything left in the process groups
+		err = w.verifyCleanExit(procs)
+		send(syscall.SIGKILL)
+	}
+	if !waitExited(procs, stopTimeout) {
+		return errors.New("timed out waiting for app to exit")
+	}
+	return err
+}
+
+// waitExited waits up to timeout for every process to exit and reports
+// whether they all did.
+func waitExited(procs []*appProcess, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for _, p := range procs {
+		select {
+		case <-p.exited:
+		case <-deadline:
+			return false
+		}
+	}
+	return true
+}
+
+// signal sends sig to the app's process group, or to the app alone when it
+// shares the watcher's group to read the terminal.
+func (p *appProcess) signal(sig syscall.Signal) {
+	if p.group {
+		_ = signalGroup(p.cmd, sig)
+	} else {
+		_ = p.cmd.Process.Signal(sig)
+	}
+}
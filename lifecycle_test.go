@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type discardSink struct{}
+
+func (discardSink) send([]logRecord) error { return nil }
+
+func TestStopLeavesNoGoroutines(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	health := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer health.Close()
+	// os/signal's delivery goroutine starts with the first Notify and never
+	// exits, so start it before counting
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	signal.Stop(sigs)
+	before := runtime.NumGoroutine()
+
+	w := NewWatcher([]string{dir}, 50*time.Millisecond, "true", "sleep 60", "", "", nil, nil)
+	// Everything Run starts a goroutine for besides the loop itself
+	w.logSinks = []logSink{discardSink{}}
+	w.mux = &logMux{status: &statusLine{out: devNull, atLineStart: true}}
+	w.healthURL, w.healthInterval, w.healthFailures = health.URL, 10*time.Millisecond, 3
+	w.quietWindows, w.quietTZ = []quietWindow{{spec: "never"}}, time.UTC
+	w.trackResources = resourceTrackingSupported
+
+	events := w.Events()
+	done := make(chan error, 1)
+	go func() { done <- w.Run() }()
+	for ev := range events {
+		if ev.Type == AppStarted {
+			break
+		}
+	}
+	// Long enough for a few health checks
+	time.Sleep(100 * time.Millisecond)
+	if err := w.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != ErrStopped {
+		t.Fatalf("Run returned %v, want ErrStopped", err)
+	}
+
+	// The loops only notice the stop on their next wake-up
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		buf := make([]byte, 1<<16)
+		t.Fatalf("%d goroutines left running after Stop, %d before Run:\n%s", n, before, buf[:runtime.Stack(buf, true)])
+	}
+}
+
+func TestStopDuringBuild(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w := NewWatcher([]string{dir}, 50*time.Millisecond, "sleep 20", "sleep 60", "", "", nil, nil)
+	w.buildKillSignal = syscall.SIGTERM
+
+	events := w.Events()
+	done := make(chan error, 1)
+	go func() { done <- w.Run() }()
+	for ev := range events {
+		if ev.Type == BuildStarted {
+			break
+		}
+	}
+	start := time.Now()
+	if err := w.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != ErrStopped {
+		t.Fatalf("Run returned %v, want ErrStopped", err)
+	}
+	// The shell and its sleep get SIGTERM rather than finishing the build
+	if d := time.Since(start); d > 3*time.Second {
+		t.Errorf("Stop took %s during a 20s build", d)
+	}
+}
+
+func TestSkipInitialBuild(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		prebuilt  bool
+		wantBuilt bool
+	}{
+		{"run target exists", true, false},
+		{"run target missing", false, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			src, out := t.TempDir(), t.TempDir()
+			if err := os.WriteFile(filepath.Join(src, "main.go"), []byte("package main\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			app, binary := filepath.Join(out, "app"), filepath.Join(t.TempDir(), "app")
+			if err := os.WriteFile(binary, []byte("#!/bin/sh\nexec sleep 60\n"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if tt.prebuilt {
+				if err := os.Link(binary, app); err != nil {
+					t.Fatal(err)
+				}
+			}
+			marker := filepath.Join(out, "built")
+			build := fmt.Sprintf("cp %s %s && touch %s", binary, app, marker)
+			w := NewWatcher([]string{src}, 50*time.Millisecond, build, app, "", "", nil, nil)
+			w.skipInitialBuild = true
+			w.checkTarget = true
+
+			events := w.Events()
+			done := make(chan error, 1)
+			go func() { done <- w.Run() }()
+			var types []EventType
+			for ev := range events {
+				types = append(types, ev.Type)
+				if ev.Type == AppStarted {
+					break
+				}
+			}
+			if err := w.Stop(); err != nil {
+				t.Fatal(err)
+			}
+			if err := <-done; err != ErrStopped {
+				t.Fatalf("Run returned %v, want ErrStopped", err)
+			}
+
+			if built := slices.Contains(types, BuildStarted); built != tt.wantBuilt {
+				t.Errorf("events %v: built = %v, want %v", types, built, tt.wantBuilt)
+			}
+			if _, err := os.Stat(marker); (err == nil) != tt.wantBuilt {
+				t.Errorf("build command ran = %v, want %v", err == nil, tt.wantBuilt)
+			}
+		})
+	}
+}
+
+func TestUnhealthyAppRestart(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	health := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer health.Close()
+
+	w := NewWatcher([]string{dir}, 50*time.Millisecond, "true", "sleep 60", "", "", nil, nil)
+	w.healthURL, w.healthInterval, w.healthFailures = health.URL, 10*time.Millisecond, 2
+	w.readyCmd, w.readyTimeout = "true", time.Second
+	events := w.Events()
+	done := make(chan error, 1)
+	go func() { done <- w.Run() }()
+	defer func() {
+		w.Stop()
+		<-done
+	}()
+
+	// The restart goes through the run loop, so it checks readiness again
+	var got []EventType
+	want := []EventType{AppStarted, AppReady, AppStarted, AppReady}
+	timeout := time.After(5 * time.Second)
+	for len(got) < len(want) {
+		select {
+		case ev := <-events:
+			if ev.Type == AppStarted || ev.Type == AppReady {
+				got = append(got, ev.Type)
+			}
+		case <-timeout:
+			t.Fatalf("got events %v, want %v", got, want)
+		}
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// parseByteSize parses sizes such as 512M, 2G or 1048576 (bytes), with
+// K, M and G as powers of 1024.
+func parseByteSize(s string) (int64, error) {
+	mult := int64(1)
+	num := strings.ToUpper(strings.TrimSpace(s))
+	switch {
+	case strings.HasSuffix(num, "K"):
+		mult, num = 1<<10, strings.TrimSuffix(num, "K")
+	case strings.HasSuffix(num, "M"):
+		mult, num = 1<<20, strings.TrimSuffix(num, "M")
+	case strings.HasSuffix(num, "G"):
+		mult, num = 1<<30, strings.TrimSuffix(num, "G")
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q (want e.g. 512M or 2G)", s)
+	}
+	return n * mult, nil
+}
+
+// memoryLimited prefixes a shell command with a virtual memory ulimit, which
+// the shell applies to itself and everything it starts. Zero means no limit.
+func memoryLimited(command string, limit int64) string {
+	if limit <= 0 {
+		return command
+	}
+	return fmt.Sprintf("ulimit -v %d; %s", limit>>10, command)
+}
+
+// checkLimits turns off the limits this platform can't apply, with a
+// warning rather than an error: they keep a shared machine responsive but
+// are no reason not to build.
+func (w *Watcher) checkLimits() {
+	if w.buildMemory > 0 || w.runMemory > 0 {
+		// Not every sh has ulimit -v (and there is no sh on plain Windows)
+		if err := exec.Command("/bin/sh", "-c", "ulimit -v 1048576").Run(); err != nil {
+			log.Printf("WARNING: memory limits need a shell with ulimit -v (%v); running without them", err)
+			w.buildMemory, w.runMemory = 0, 0
+		}
+	}
+	if w.buildNice != 0 || w.runNice != 0 {
+		if _, err := exec.LookPath("nice"); err != nil {
+			log.Printf("WARNING: --build-nice and --run-nice need nice (%v); running at normal priority", err)
+			w.buildNice, w.runNice = 0, 0
+		}
+	}
+}
+
+// niced makes cmd run under nice at the given niceness, so the shell and
+// everything it starts inherit it. Where the kernel derives I/O priority from
+// niceness (CFQ and BFQ on Linux) that drops too, as with ionice.
+func niced(cmd *exec.Cmd, nice int) {
+	if nice == 0 {
+		return
+	}
+	path, err := exec.LookPath("nice")
+	if err != nil {
+		return // checkLimits already warned
+	}
+	cmd.Path = path
+	cmd.Args = append([]string{"nice", "-n", strconv.Itoa(nice)}, cmd.Args...)
+}
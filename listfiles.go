@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// listFiles scans the roots once and writes every watched path to out, one
+// per line and sorted so the output diffs cleanly. In verbose mode skipped
+// paths follow, each with the rule that skipped it; skipped directories end
+// in a separator and stand for everything under them.
+func (w *Watcher) listFiles(out io.Writer) error {
+	scan := scanResult{files: make(map[string]fileState)}
+	if w.verbose {
+		scan.rejects = make(map[string]string)
+	}
+	if err := w.scanInto(&scan); err != nil {
+		return err
+	}
+
+	for _, name := range sortedKeys(scan.files) {
+		fmt.Fprintln(out, name)
+	}
+	for _, name := range sortedKeys(scan.rejects) {
+		fmt.Fprintf(out, "skipped %s (%s)\n", name, scan.rejects[name])
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
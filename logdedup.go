@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// walkErrorWindow is the minimum time between repeats of an identical walk
+// error in the log.
+const walkErrorWindow = 30 * time.Second
+
+// errorDedup suppresses identical messages seen within walkErrorWindow and
+// reports how many were dropped when the message is next logged. A file
+// churning inside the tree would otherwise log the same error every tick.
+type errorDedup struct {
+	mu      sync.Mutex
+	entries map[string]*dedupEntry
+}
+
+type dedupEntry struct {
+	logged     time.Time
+	seen       time.Time
+	suppressed int
+}
+
+func (d *errorDedup) logf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.entries == nil {
+		d.entries = make(map[string]*dedupEntry)
+	}
+	for key, e := range d.entries {
+		if now.Sub(e.seen) > 2*walkErrorWindow {
+			delete(d.entries, key)
+		}
+	}
+
+	e, ok := d.entries[msg]
+	if !ok {
+		d.entries[msg] = &dedupEntry{logged: now, seen: now}
+		log.Print(msg)
+		return
+	}
+	e.seen = now
+	if now.Sub(e.logged) < walkErrorWindow {
+		e.suppressed++
+		return
+	}
+	if e.suppressed > 0 {
+		log.Printf("%s (repeated %d more times)", msg, e.suppressed)
+	} else {
+		log.Print(msg)
+	}
+	e.logged = now
+	e.suppressed = 0
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// partialLineDelay is how long a line without its newline (e.g. a prompt) is
+// held back before it is written anyway.
+const partialLineDelay = 50 * time.Millisecond
+
+// logMux serializes the watcher's logs and every child's output so that
+// lines are written whole and never torn by a concurrent writer. Each source
+// writes through its own facet from writer.
+type logMux struct {
+	mu     sync.Mutex
+	status *statusLine // with --status-line, else nil
+}
+
+// writer returns a facet that writes complete lines to dst while holding
+// the mux lock.
+func (m *logMux) writer(dst io.Writer) io.Writer {
+	return &muxWriter{mux: m, dst: dst}
+}
+
+type muxWriter struct {
+	mux *logMux
+	dst io.Writer
+
+	mu      sync.Mutex
+	partial []byte
+	timer   *time.Timer
+}
+
+func (w *muxWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.partial = append(w.partial, p...)
+	if i := bytes.LastIndexByte(w.partial, '\n'); i >= 0 {
+		lines := w.partial[:i+1]
+		w.partial = append([]byte(nil), w.partial[i+1:]...)
+		if err := w.emit(lines); err != nil {
+			return 0, err
+		}
+		// What's left, if anything, is a new partial line with its own wait
+		if w.timer != nil {
+			w.timer.Stop()
+			w.timer = nil
+		}
+	}
+	if len(w.partial) > 0 && w.timer == nil {
+		var t *time.Timer
+		t = time.AfterFunc(partialLineDelay, func() { w.flush(t) })
+		w.timer = t
+	}
+	return len(p), nil
+}
+
+func (w *muxWriter) emit(b []byte) error {
+	w.mux.mu.Lock()
+	defer w.mux.mu.Unlock()
+	s := w.mux.status
+	if s != nil {
+		s.clear()
+	}
+	_, err := w.dst.Write(b)
+	if s != nil {
+		s.atLineStart = bytes.HasSuffix(b, []byte("\n"))
+		s.draw()
+	}
+	return err
+}
+
+// flush writes out a partial line that has waited partialLineDelay, unless
+// t was stopped for a newline while flush waited for the lock.
+func (w *muxWriter) flush(t *time.Timer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timer != t {
+		return
+	}
+	w.timer = nil
+	if len(w.partial) > 0 {
+		_ = w.emit(w.partial)
+		w.partial = nil
+	}
+}
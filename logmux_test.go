@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOutputWriterSerializesLines(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := NewWatcher([]string{"."}, 0, "", "", "", "", nil, nil)
+	w.mux = &logMux{}
+
+	const writers, lines = 8, 500
+	line := func(i, j int) string {
+		return fmt.Sprintf("app%d line %d %s\n", i, j, strings.Repeat("x", j%50))
+	}
+	// The writers go in lockstep, each writing a piece of its line at a
+	// time, so pieces of different lines always meet between newlines;
+	// each step is quick enough that partialLineDelay never flushes one.
+	steps := make([]chan []byte, writers)
+	wrote := make(chan error)
+	for i := range writers {
+		steps[i] = make(chan []byte)
+		out := w.outputWriter(fmt.Sprintf("app%d", i), f)
+		go func() {
+			for piece := range steps[i] {
+				_, err := out.Write(piece)
+				wrote <- err
+			}
+		}()
+	}
+	for j := range lines {
+		for _, cut := range [][2]int{{0, 5}, {5, 9}, {9, -1}} {
+			for i := range writers {
+				l := line(i, j)
+				end := cut[1]
+				if end < 0 {
+					end = len(l)
+				}
+				steps[i] <- []byte(l[cut[0]:end])
+			}
+			for range writers {
+				if err := <-wrote; err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	for i := range writers {
+		close(steps[i])
+	}
+
+	want := make(map[string]bool)
+	for i := range writers {
+		for j := range lines {
+			want[fmt.Sprintf("[app%d] %s", i, strings.TrimSuffix(line(i, j), "\n"))] = true
+		}
+	}
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	for _, l := range got {
+		if !want[l] {
+			t.Fatalf("torn line %q", l)
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d lines, want %d", len(got), len(want))
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logHistory is how many output lines the watcher keeps for late readers
+// of /logs/stream, such as `poly-watcher logs --since 5m`.
+const logHistory = 1000
+
+// outputLog fans build and app output out to /logs/stream clients and
+// remembers the last logHistory lines.
+type outputLog struct {
+	hub[outputLine]
+
+	mu    sync.Mutex
+	lines []outputLine
+}
+
+func (o *outputLog) publish(line outputLine) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.lines) == logHistory {
+		o.lines = o.lines[1:]
+	}
+	o.lines = append(o.lines, line)
+	o.hub.publish(line)
+}
+
+// subscribeSince returns the remembered lines from since on and a
+// subscription for the ones after them, with none lost or repeated between
+// the two.
+func (o *outputLog) subscribeSince(buffer int, since time.Time) ([]outputLine, chan outputLine) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var backlog []outputLine
+	for _, line := range o.lines {
+		if !line.Time.Before(since) {
+			backlog = append(backlog, line)
+		}
+	}
+	return backlog, o.hub.subscribe(buffer)
+}
+
+// logFilter selects lines for /logs/stream from its query: since (a
+// duration back from now, or an RFC 3339 time), level ("error" for stderr
+// only) and source ("build", "app", "app1"...).
+type logFilter struct {
+	since  time.Time
+	stderr bool
+	source string
+}
+
+func parseLogFilter(q url.Values) (logFilter, error) {
+	var f logFilter
+	if s := q.Get("since"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil {
+			f.since = time.Now().Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, s); err == nil {
+			f.since = t
+		} else {
+			return f, fmt.Errorf("invalid since %q: want a duration or an RFC 3339 time", s)
+		}
+	}
+	switch level := q.Get("level"); level {
+	case "", "info":
+	case "error":
+		f.stderr = true
+	default:
+		return f, fmt.Errorf("invalid level %q: want info or error", level)
+	}
+	f.source = q.Get("source")
+	return f, nil
+}
+
+func (f logFilter) keep(line outputLine) bool {
+	if f.stderr && line.Stream != "stderr" {
+		return false
+	}
+	return f.source == "" || line.Source == f.source
+}
+
+// watcherAddr finds a running watcher's status server for `poly-watcher
+// logs` and --history: the --http flag, else POLY_HTTP, else the http
+// setting in poly.yaml.
+func watcherAddr(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if v := os.Getenv(envName("http")); v != "" {
+		return v
+	}
+	cf, err := parseConfigFile(defaultConfigFile)
+	if err != nil {
+		return ""
+	}
+	for _, e := range cf.entries {
+		if e.key == "http" && len(e.values) == 1 {
+			return e.values[0]
+		}
+	}
+	return ""
+}
+
+// runLogs implements `poly-watcher logs`: it prints build and app output
+// from a running watcher's /logs/stream, following it with -f.
+func runLogs(args []string) error {
+	fs := flag.NewFlagSet("logs", flag.ContinueOnError)
+	addr := fs.String("http", "", "Address of the watcher's --http server (default: $POLY_HTTP, then http in poly.yaml)")
+	follow := fs.Bool("f", false, "Keep printing new output until interrupted")
+	since := fs.String("since", "", "Only show output from this long ago (e.g. 10m) or since this RFC 3339 time; default all the watcher still has")
+	level := fs.String("level", "info", "Lowest level to show: info (everything) or error (stderr only)")
+	source := fs.String("source", "", "Only show output from this source: build, app, or app1, app2... with several run commands")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: poly-watcher logs [-f] [--since duration] [--level info|error] [--source name] [--http addr]")
+		fmt.Fprintln(fs.Output(), "Prints build and app output from a watcher (e.g. a --daemon) started with --http.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	base := watcherAddr(*addr)
+	if base == "" {
+		return fmt.Errorf("no watcher address; pass --http with the address the watcher's --http server listens on")
+	}
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	q := url.Values{"level": {*level}, "since": {*since}}
+	if *since == "" {
+		// Everything the watcher still remembers, not just new output
+		q.Set("since", time.Time{}.Format(time.RFC3339))
+	}
+	if *source != "" {
+		q.Set("source", *source)
+	}
+	if !*follow {
+		q.Set("follow", "0")
+	}
+
+	resp, err := http.Get(strings.TrimSuffix(base, "/") + "/logs/stream?" + q.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var msg [512]byte
+		n, _ := resp.Body.Read(msg[:])
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg[:n])))
+	}
+
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		data, ok := strings.CutPrefix(sc.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var line outputLine
+		if err := json.Unmarshal([]byte(data), &line); err != nil {
+			return fmt.Errorf("bad log message: %v", err)
+		}
+		fmt.Printf("%s [%s] %s\n", line.Time.Local().Format("15:04:05"), line.Source, line.Line)
+	}
+	return sc.Err()
+}
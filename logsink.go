@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// logRecord is one event or output line on its way to a --log-sink.
+type logRecord struct {
+	time   time.Time
+	source string // "watcher" for events, else the output's source
+	stream string // "stdout", "stderr" or "event"
+	err    bool   // a stderr line or a failure event
+	msg    string
+}
+
+func eventRecord(ev Event) logRecord {
+	b, _ := json.Marshal(ev)
+	return logRecord{time: ev.Time, source: "watcher", stream: "event", err: ev.Err != nil, msg: string(b)}
+}
+
+func outputRecord(l outputLine) logRecord {
+	return logRecord{time: l.Time, source: l.Source, stream: l.Stream, err: l.Stream == "stderr", msg: l.Line}
+}
+
+// logSink delivers records to a log aggregator. send is only called from
+// the sink's own goroutine, so it may block without stalling builds.
+type logSink interface {
+	send(recs []logRecord) error
+}
+
+// parseLogSink creates the sink for a --log-sink value: syslog (the local
+// daemon), syslog://host:port (UDP), syslog+tcp://host:port, journald, or an
+// http(s) URL of a Loki push endpoint.
+func parseLogSink(spec string) (logSink, error) {
+	switch {
+	case spec == "syslog":
+		return &syslogSink{}, nil
+	case spec == "journald":
+		return &journaldSink{}, nil
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		if _, err := url.Parse(spec); err != nil {
+			return nil, fmt.Errorf("invalid --log-sink %q: %v", spec, err)
+		}
+		return &lokiSink{url: spec, client: &http.Client{Timeout: 5 * time.Second}}, nil
+	}
+	u, err := url.Parse(spec)
+	if err == nil && (u.Scheme == "syslog" || u.Scheme == "syslog+tcp") && u.Host != "" {
+		network := "udp"
+		if u.Scheme == "syslog+tcp" {
+			network = "tcp"
+		}
+		return &syslogSink{network: network, addr: u.Host}, nil
+	}
+	return nil, fmt.Errorf("invalid --log-sink %q (want syslog, syslog://host:port, syslog+tcp://host:port, journald or a Loki push URL)", spec)
+}
+
+const (
+	sinkBuffer     = 1024 // records buffered per sink; more are dropped
+	sinkBatch      = 100
+	sinkFlushEvery = time.Second
+)
+
+// sinkLoop forwards events and output to sink in batches, until the
+// watcher stops. It reads from bounded hub subscriptions, so when the sink
+// can't keep up new records are dropped instead of blocking the watcher.
+func (w *Watcher) sinkLoop(sink logSink, events chan Event, output chan outputLine, done chan<- struct{}) {
+	defer close(done)
+	defer w.eventHub.unsubscribe(events)
+	defer w.output.unsubscribe(output)
+
+	var batch []logRecord
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := sink.send(batch); err != nil {
+			w.sinkErrors.logf("Log sink failed, dropping its records: %v", err)
+		}
+		batch = nil
+	}
+	tick := time.NewTicker(sinkFlushEvery)
+	defer tick.Stop()
+	for {
+		select {
+		case ev := <-events:
+			batch = append(batch, eventRecord(ev))
+		case l := <-output:
+			batch = append(batch, outputRecord(l))
+		case <-tick.C:
+			flush()
+			continue
+		case <-w.stopCh:
+			flush()
+			return
+		}
+		if len(batch) >= sinkBatch {
+			flush()
+		}
+	}
+}
+
+// syslogSink writes RFC 3164 messages with facility user, to the local
+// daemon's socket or to a remote host.
+type syslogSink struct {
+	network, addr string
+	conn          net.Conn
+}
+
+// localSyslogSockets are where syslog daemons listen on Linux, macOS and
+// the BSDs.
+var localSyslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+func (s *syslogSink) dial() (net.Conn, error) {
+	if s.addr != "" {
+		return net.DialTimeout(s.network, s.addr, 5*time.Second)
+	}
+	var err error
+	for _, path := range localSyslogSockets {
+		for _, network := range []string{"unixgram", "unix"} {
+			var c net.Conn
+			if c, err = net.Dial(network, path); err == nil {
+				return c, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no local syslog daemon: %v", err)
+}
+
+func (s *syslogSink) send(recs []logRecord) error {
+	if s.conn == nil {
+		c, err := s.dial()
+		if err != nil {
+			return err
+		}
+		s.conn = c
+	}
+	host, _ := os.Hostname()
+	for _, r := range recs {
+		severity := 6 // info
+		if r.err {
+			severity = 3 // err
+		}
+		msg := fmt.Sprintf("<%d>%s ", 1<<3|severity, r.time.Format(time.Stamp))
+		if s.addr != "" {
+			msg += host + " "
+		}
+		msg += fmt.Sprintf("poly-watcher[%d]: [%s] %s", os.Getpid(), r.source, r.msg)
+		if s.network == "tcp" {
+			msg += "\n"
+		}
+		if _, err := s.conn.Write([]byte(msg)); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// journaldSink writes to journald's native socket, with the source and
+// stream as POLY_SOURCE and POLY_STREAM fields.
+type journaldSink struct {
+	conn net.Conn
+}
+
+const journaldSocket = "/run/systemd/journal/socket"
+
+func (j *journaldSink) send(recs []logRecord) error {
+	if j.conn == nil {
+		c, err := net.Dial("unixgram", journaldSocket)
+		if err != nil {
+			return err
+		}
+		j.conn = c
+	}
+	for _, r := range recs {
+		priority := "6"
+		if r.err {
+			priority = "3"
+		}
+		var b bytes.Buffer
+		for _, f := range [][2]string{
+			{"MESSAGE", r.msg}, {"PRIORITY", priority}, {"SYSLOG_IDENTIFIER", "poly-watcher"},
+			{"POLY_SOURCE", r.source}, {"POLY_STREAM", r.stream},
+		} {
+			journaldField(&b, f[0], f[1])
+		}
+		if _, err := j.conn.Write(b.Bytes()); err != nil {
+			j.conn.Close()
+			j.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// journaldField appends a field in the native protocol: KEY=value, or the
+// length-prefixed form for a value with a newline in it.
+func journaldField(b *bytes.Buffer, key, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(b, "%s=%s\n", key, value)
+		return
+	}
+	b.WriteString(key + "\n")
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
+
+// lokiSink pushes records to Loki's /loki/api/v1/push, one stream per
+// source and output stream, labelled job="poly-watcher".
+type lokiSink struct {
+	url    string
+	client *http.Client
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (l *lokiSink) send(recs []logRecord) error {
+	streams := make(map[[2]string]*lokiStream)
+	var order []*lokiStream
+	for _, r := range recs {
+		key := [2]string{r.source, r.stream}
+		s, ok := streams[key]
+		if !ok {
+			s = &lokiStream{Stream: map[string]string{"job": "poly-watcher", "source": r.source, "stream": r.stream}}
+			streams[key] = s
+			order = append(order, s)
+		}
+		s.Values = append(s.Values, [2]string{strconv.FormatInt(r.time.UnixNano(), 10), r.msg})
+	}
+	body, err := json.Marshal(map[string]any{"streams": order})
+	if err != nil {
+		return err
+	}
+	resp, err := l.client.Post(l.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s: unexpected status %s", l.url, resp.Status)
+	}
+	return nil
+}
@@ -1,191 +1,1137 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
-	"hash/fnv"
+	"hash"
+	"io/fs"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 type Watcher struct {
-	dir          string
-	interval     time.Duration
-	buildCmd     string
-	runCmd       string
-	includes     []string
-	excludes     []string
-	depFile      string
-	depCmd       string
-	prevHash     uint64
-	prevDepMTime time.Time
-	process      *exec.Cmd
-	processMu    sync.Mutex
-}
-
-func NewWatcher(dir string, interval time.Duration, buildCmd, runCmd, depFile, depCmd string, includes, excludes []string) *Watcher {
+	roots          []string
+	interval       time.Duration
+	buildCmd       string
+	checkCmd       string
+	runCmds        []string // guarded by processMu once Run has started
+	buildWrapper   string
+	runWrapper     string
+	attachStdin    bool
+	crashTailLines int
+	lastCrash      *crashReport // guarded by processMu
+	checkTarget    bool
+
+	shadowBuild       bool
+	shadowOutputs     []string
+	buildRetries      int
+	buildRetryBackoff time.Duration
+	buildHeartbeat    time.Duration
+	buildMatrix       []matrixEntry
+	buildParallelism  int
+	goIncremental     bool
+	templateCmds      bool
+	templateVars      map[string]string
+	buildChanged      []string // files changed since the last successful build, for {{.Changed}}
+	profile           string
+	watchCmd          string
+	watchCmdInterval  time.Duration
+	watchCmdTimeout   time.Duration
+	watchSet          watchSet
+	goGraph           *goGraph
+	preStop           string
+	preRunDelay       time.Duration
+	readyCmd          string
+	readyTimeout      time.Duration
+	waitFor           []waitCondition
+	waitTimeout       time.Duration
+	drainTimeout      time.Duration
+	runStopSignal     syscall.Signal
+	buildTimeout      time.Duration
+	buildKillSignal   syscall.Signal
+	buildLadder       []signalStep // nil: derived from buildKillSignal
+	stopLadder        []signalStep // nil: derived from runStopSignal and drainTimeout
+	reloadSignal      syscall.Signal
+	reloadOn          []string
+	buildNice         int
+	runNice           int
+	buildMemory       int64 // bytes, 0 for no limit
+	runMemory         int64
+	includes          []string
+	excludes          []string
+	depRules          []depRule
+	newHash           func() hash.Hash
+	prevHash          string
+	deps              depTracker
+	ownPaths          []string // daemon pidfile and log file, --manifest, --build-lock and --app-stdout files
+	appStdoutPath     string
+	appStdout         *os.File // opened once by Run, shared by every app process
+	stateFile         string
+	configHash        string // fingerprint of the settings, invalidates stale state
+	builtHash         string // tree hash of the last successful build
+	builtFiles        map[string]fileState
+	prevFiles         map[string]fileState
+	processes         []*appProcess // one per run command, guarded by processMu
+	processMu         sync.Mutex
+	verbose           bool
+
+	healthURL      string
+	healthInterval time.Duration
+	healthFailures int
+	cycling        atomic.Bool  // set while a rebuild or restart is in progress
+	buildStart     atomic.Int64 // UnixNano start of the running build, 0 when idle
+
+	events   eventStream
+	triggers chan trigger
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	started  atomic.Bool
+	done     chan struct{} // closed when Run returns
+
+	pendingRunCmd string // set by SetRunCommand, guarded by processMu
+
+	changeSourceCmd string
+	sourceMu        sync.Mutex
+	sourceEvents    []sourceEvent // not yet built, guarded by sourceMu
+	sourceQueued    bool          // a triggerSource is queued, guarded by sourceMu
+
+	watchSelf  bool
+	selfPaths  []string  // config file and binary, for --watch-self
+	selfExe    string    // the binary, resolved at startup
+	selfExecAt time.Time // when a --watch-self re-exec started this process
+
+	walkErrors          errorDedup
+	openFS              func(root string) fs.FS // filesystem each root is walked through
+	maxFiles            int
+	baselined           bool // the first scan succeeded, so --max-files only warns
+	hashDirs            bool
+	generatedPaths      []string
+	restartGrace        time.Duration
+	graceAllow          []string
+	appStartedAt        atomic.Int64 // UnixNano of the last startApp, for --post-restart-grace
+	absorbWrites        bool
+	hashMode            bool
+	hashContent         bool
+	dirMtimePrescan     bool
+	skipInitialBuild    bool
+	failFast            bool
+	ensureDirs          bool
+	steps               []buildStep            // --build-step commands, run after the build
+	scopes              map[string]actionScope // --scope of each action that has one
+	asyncDeps           string                 // step that waits for background dep commands, empty to run them first
+	buildErr            error                  // the failed build that ends Run, with --fail-fast
+	cleanExit           string                 // --verify-clean-exit mode, empty when off
+	buildLock           string                 // --build-lock file, empty when unset
+	buildLockTimeout    time.Duration          // how long a build waits for it
+	buildLockPending    bool                   // a build was skipped for the --build-lock and is owed a retry
+	uncleanExit         error                  // the leak that ends Run, with --verify-clean-exit fail; guarded by processMu
+	contentExclude      *regexp.Regexp         // --exclude-content, nil when unset
+	contentExcludeBytes int
+	contentMarks        map[string]contentMark
+	legacyRules         legacyWarnings // rules that lost a match to name rules
+	hashSymlinkTargets  bool
+	hashSize            bool // with hashMTime, the --hash-include components besides path
+	hashMTime           bool
+
+	paused             atomic.Bool
+	changedWhilePaused bool
+	quietWindows       []quietWindow
+	quietTZ            *time.Location
+	quietPaused        bool // paused by --quiet-schedule, not by the user
+	rebuildOnResume    bool
+
+	debounce      time.Duration
+	debounceRules []debounceRule
+	settleTime    time.Duration
+	dedupeSaves   bool
+
+	largeThreshold int           // --large-change-threshold, 0 when off
+	largeSettle    time.Duration // quiet window for a large change
+	cleanCmd       string
+	fullRebuild    bool                             // set for the build of a large change
+	contentSums    map[string]contentSum            // modified files' content, for --dedupe-saves
+	dirListings    map[string]map[string]dirListing // per root and directory, for --dir-mtime-prescan
+	contentCache   map[string]contentStamp          // every file's content, for --hash-content
+
+	failOnStderr   bool
+	failPattern    *regexp.Regexp
+	successPattern *regexp.Regexp
+	diags          *diagCounter // errors and warnings in the build's output
+	maxWarnings    int          // -1 for no limit
+	noRestartCode  int
+
+	maxIdle      time.Duration
+	maxRuntime   time.Duration
+	lastActivity time.Time // last detected change or trigger, for maxIdle
+
+	watchGit         bool
+	gitHeadState     string // HEAD of each root's repository as of the last check
+	gitHeadSeen      bool
+	gitHeadPending   bool // HEAD moved while paused, and no build has run since
+	triggerFile      string
+	triggerFileMTime time.Time
+	triggerFileSeen  bool
+
+	mux            *logMux                            // serializes all output with --serialize-output, else nil
+	sections       *sectionMarkers                    // with --section-markers, else nil
+	fileCount      atomic.Int64                       // files in the last scan, for --status-line
+	checkpoints    checkpoints                        // for POST /checkpoint and GET /diff
+	lastExplain    atomic.Pointer[rebuildExplanation] // the last rebuild, for GET /explain
+	stripANSI      string
+	httpAddr       string
+	httpToken      string
+	tlsCert        string
+	tlsKey         string
+	trackResources bool
+	resources      resourceTracker
+	eventHub       hub[Event]
+	output         outputLog
+	logSinks       []logSink
+	sinkErrors     errorDedup
+	history        cycleHistory
+}
+
+// SetEventBuffer sets the capacity of the channel returned by Events. It must
+// be called before Events.
+func (w *Watcher) SetEventBuffer(n int) {
+	w.events.mu.Lock()
+	w.events.buffer = n
+	w.events.mu.Unlock()
+}
+
+// NewWatcher returns a watcher polling roots every interval, which is raised
+// to minInterval if lower.
+func NewWatcher(roots []string, interval time.Duration, buildCmd, runCmd, depFile, depCmd string, includes, excludes []string) *Watcher {
+	interval = max(interval, minInterval)
+	var deps []depRule
+	if depFile != "" && depCmd != "" {
+		deps = append(deps, depRule{file: depFile, command: depCmd})
+	}
 	return &Watcher{
-		dir:      dir,
+		depRules: deps,
+		roots:    roots,
 		interval: interval,
 		buildCmd: buildCmd,
-		runCmd:   runCmd,
-		depFile:  depFile,
-		depCmd:   depCmd,
+		runCmds:  []string{runCmd},
 		includes: includes,
 		excludes: excludes,
+		newHash:  hashAlgorithms[defaultHashAlgo],
+		openFS:   func(root string) fs.FS { return os.DirFS(root) },
+		triggers: make(chan trigger, 8),
+		stopCh:   make(chan struct{}),
+		done:     make(chan struct{}),
+
+		hashSize:        true,
+		hashMTime:       true,
+		runStopSignal:   syscall.SIGTERM,
+		buildKillSignal: syscall.SIGKILL,
+		diags:           newDiagCounter("", ""),
+		maxWarnings:     -1,
+		watchCmdTimeout: defaultWatchCmdTimeout,
 	}
 }
 
+func (w *Watcher) excluded(relPath string) bool {
+	for _, ex := range w.excludes {
+		if w.ruleMatches("exclude", ex, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
 func (w *Watcher) shouldProcess(relPath string) bool {
+	return w.rejectReason(relPath) == ""
+}
+
+// rejectReason explains why the include/exclude rules skip relPath, or
+// returns "" if the file is watched.
+func (w *Watcher) rejectReason(relPath string) string {
 	for _, ex := range w.excludes {
-		if strings.HasPrefix(relPath, ex) || strings.HasSuffix(relPath, ex) {
-			return false
+		if w.ruleMatches("exclude", ex, relPath) {
+			return fmt.Sprintf("excluded by %q", ex)
 		}
 	}
 	if len(w.includes) == 0 {
-		return true
+		return ""
 	}
 	for _, in := range w.includes {
-		if strings.HasPrefix(relPath, in) || strings.HasSuffix(relPath, in) {
-			return true
+		if w.ruleMatches("include", in, relPath) {
+			return ""
 		}
 	}
-	return false
+	return "matches no include"
 }
 
-func (w *Watcher) hashDir() (uint64, bool, error) {
-	h := fnv.New64a()
-	depChanged := false
+// scanResult is the outcome of a single walk of the watched tree.
+type scanResult struct {
+	hash     string
+	files    map[string]fileState
+	rejected int // files skipped by include/exclude rules
+	dirs     int // directories walked
+	// hashedDirs counts the directory entries in files, with --hash-dirs
+	hashedDirs int
+	// rejects maps each skipped path to the reason; only recorded when
+	// non-nil, as for --list-files
+	rejects map[string]string
+}
+
+// watched is the number of files in the scan, leaving out --hash-dirs
+// directory entries.
+func (s *scanResult) watched() int {
+	return len(s.files) - s.hashedDirs
+}
+
+func (s *scanResult) reject(name, reason string) {
+	if s.rejects != nil {
+		s.rejects[name] = reason
+	}
+}
+
+func (w *Watcher) debugf(format string, args ...any) {
+	if w.verbose {
+		log.Printf(format, args...)
+	}
+}
+
+func (w *Watcher) hashDir() (scanResult, error) {
+	scan := scanResult{files: make(map[string]fileState)}
+	if err := w.scanInto(&scan); err != nil {
+		return scanResult{}, err
+	}
+	w.checkpoints.setCurrent(scan.files)
+	return scan, nil
+}
+
+// scanInto walks every root into scan, or hashes the --watch-cmd file set
+// instead, and sets its hash.
+func (w *Watcher) scanInto(scan *scanResult) error {
+	h := w.newHash()
+	if w.watchCmd != "" {
+		if err := w.scanWatchSet(h, scan); err != nil {
+			return err
+		}
+		scan.hash = string(h.Sum(nil))
+		w.fileCount.Store(int64(len(scan.files)))
+		return nil
+	}
+	for _, root := range w.roots {
+		if err := w.walkRoot(root, h, scan); err != nil {
+			return err
+		}
+	}
+	if w.hashContent {
+		w.pruneContentCache(scan.files)
+	}
+	scan.hash = string(h.Sum(nil))
+	w.fileCount.Store(int64(scan.watched()))
+	return nil
+}
+
+// walkRoot folds the files under root into h and scan. Include and exclude
+// rules see paths relative to root; the recorded path is prefixed with the
+// root so files from different roots never collide. The walk goes through
+// the root's fs.FS, which is the OS filesystem unless w.openFS says
+// otherwise.
+func (w *Watcher) walkRoot(root string, h hash.Hash, scan *scanResult) error {
+	fsys := w.openFS(root)
+	if w.dirMtimePrescan {
+		var done func()
+		fsys, done = w.prescanned(root, fsys)
+		defer done()
+	}
+	return fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		// fs.FS paths always use forward slashes
+		relPath := filepath.FromSlash(p)
+		name := filepath.Join(root, relPath)
 
-	err := filepath.Walk(w.dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			log.Printf("Error accessing %s: %v", path, err)
+			// An unreadable root fails the whole scan; anything below it is
+			// logged and skipped
+			if p == "." {
+				return fmt.Errorf("reading root %s: %w", root, err)
+			}
+			w.walkErrors.logf("Error accessing %s: %v", name, err)
 			return nil
 		}
-		if info == nil {
-			log.Printf("No info for %s", path)
+		info, err := d.Info()
+		if err != nil {
+			w.walkErrors.logf("No info for %s: %v", name, err)
 			return nil
 		}
 
-		relPath, _ := filepath.Rel(w.dir, path)
-
 		if info.IsDir() {
 			// Skip hidden subdirs, but not root
-			if info.Name() != "." && info.Name()[0] == '.' {
-				return filepath.SkipDir
+			if relPath != "." && info.Name()[0] == '.' {
+				scan.reject(name+string(filepath.Separator), "hidden directory")
+				return fs.SkipDir
 			}
+			// Don't descend into excluded subtrees at all
+			if relPath != "." && w.excluded(relPath) {
+				scan.reject(name+string(filepath.Separator), w.rejectReason(relPath))
+				return fs.SkipDir
+			}
+			// Nor into ones no include rule can reach
+			if relPath != "." && !w.mayContainIncluded(relPath) {
+				scan.reject(name+string(filepath.Separator), "no include can match below it")
+				return fs.SkipDir
+			}
+			scan.dirs++
+			if w.hashDirs && relPath != "." {
+				// Recorded with a trailing separator so it can't collide
+				// with a file of the same name
+				key := name + string(filepath.Separator)
+				st := fileState{}
+				if w.hashMode {
+					st.mode = info.Mode()
+				}
+				h.Write([]byte(fmt.Sprintf("%s %s", key, st.mode)))
+				scan.files[key] = st
+				scan.hashedDirs++
+			}
+			return nil
+		}
+
+		// Files poly-watcher manages itself are never source changes
+		if w.ownFile(name) {
 			return nil
 		}
 
+		// Only the first scan fails: a tree that grows past the limit later,
+		// e.g. on npm install, is still watched
+		if w.maxFiles > 0 && scan.watched()+scan.rejected == w.maxFiles {
+			if !w.baselined {
+				return fmt.Errorf("more than %d files under %s (--max-files); narrow --root or add --exclude rules", w.maxFiles, root)
+			}
+			w.walkErrors.logf("Warning: more than %d files under %s (--max-files); still watching them", w.maxFiles, root)
+		}
+
 		// Apply file excludes
-		if !w.shouldProcess(relPath) {
+		if reason := w.rejectReason(relPath); reason != "" {
+			scan.rejected++
+			scan.reject(name, reason)
+			return nil
+		}
+		if w.contentExclude != nil && info.Mode().IsRegular() && w.contentExcluded(fsys, p, name, info) {
+			scan.rejected++
+			scan.reject(name, "content matches --exclude-content")
 			return nil
 		}
 
-		// Include in hash
-		h.Write([]byte(relPath))
+		w.addFile(h, scan, fsys, p, name, info)
+		return nil
+	})
+}
+
+// addFile folds file p of fsys, recorded as name, into h and scan, and
+// tracks it if it is a dep file.
+func (w *Watcher) addFile(h hash.Hash, scan *scanResult, fsys fs.FS, p, name string, info fs.FileInfo) {
+	h.Write([]byte(name))
+	if w.hashSize {
 		h.Write([]byte(fmt.Sprintf("%d", info.Size())))
+	}
+	st := fileState{size: info.Size(), modTime: info.ModTime()}
+	if w.hashContent && info.Mode().IsRegular() {
+		sum, err := w.fileContentSum(fsys, p, name, info)
+		if err != nil {
+			// Unreadable: fall back to its metadata
+			w.walkErrors.logf("Error hashing %s: %v", name, err)
+		}
+		st.sum = sum
+	}
+	if st.sum != "" {
+		h.Write([]byte(st.sum))
+	}
+	if w.hashMTime || w.hashContent && st.sum == "" {
+		// An unreadable file falls back to its mtime
 		h.Write([]byte(info.ModTime().String()))
+	}
 
-		// Check dep file change
-		if w.depFile != "" && filepath.Base(path) == filepath.Base(w.depFile) {
-			if info.ModTime() != w.prevDepMTime {
-				depChanged = true
-				w.prevDepMTime = info.ModTime()
-			}
+	if w.hashSymlinkTargets && info.Mode()&fs.ModeSymlink != 0 {
+		st = symlinkTargetState(fsys, p, st)
+		h.Write([]byte(fmt.Sprintf("->%d %s", st.size, st.modTime)))
+	}
+	if w.hashMode {
+		st.mode = info.Mode()
+		h.Write([]byte(st.mode.String()))
+	}
+	// What --hash-include leaves out mustn't make the file look modified
+	if !w.hashSize {
+		st.size = 0
+	}
+	if !w.hashMTime && !w.hashContent {
+		st.modTime = time.Time{}
+	}
+	scan.files[name] = st
+
+	// Check dep file change
+	for i, rule := range w.depRules {
+		if info.Name() == filepath.Base(rule.file) {
+			w.trackDepFile(i, fsys, p, name, info)
+			break
 		}
-		return nil
-	})
+	}
+}
 
+// ownFile reports whether name is the trigger file, the state file (or a
+// state file being written), a daemon's pidfile or log file, the build lock
+// or the app's output file, none of which are part of the tree.
+func (w *Watcher) ownFile(name string) bool {
+	if w.triggerFile != "" && name == w.triggerFile {
+		return true
+	}
+	if w.stateFile != "" && (name == w.stateFile || strings.HasPrefix(filepath.Base(name), stateTempPrefix)) {
+		return true
+	}
+	if slices.Contains(w.ownPaths, name) {
+		return true
+	}
+	return false
+}
+
+// symlinkTargetState returns the metadata of the file a symlink points to, so
+// edits to the target change the hash. Directory targets are not followed
+// and keep the link's own state; a broken link is a stable "missing" entry.
+func symlinkTargetState(fsys fs.FS, path string, link fileState) fileState {
+	target, err := fs.Stat(fsys, path)
 	if err != nil {
-		return 0, false, err
+		return fileState{size: -1}
+	}
+	if target.IsDir() {
+		return link
 	}
-	return h.Sum64(), depChanged, nil
+	return fileState{size: target.Size(), modTime: target.ModTime()}
+}
+
+// wrapCommand prepends a wrapper such as "time" or "dlv exec --" to command.
+func wrapCommand(wrapper, command string) string {
+	if wrapper == "" || command == "" {
+		return command
+	}
+	return wrapper + " " + command
 }
 
 func (w *Watcher) runShell(command string) error {
+	return w.runShellIn("", command, nil)
+}
+
+// runShellIn runs command with dir as its working directory, an empty dir
+// meaning the watcher's own, and env added to the environment.
+func (w *Watcher) runShellIn(dir, command string, env []string) error {
 	if command == "" {
 		return nil
 	}
+	return w.shellCmd(dir, command, env).Run()
+}
+
+func (w *Watcher) shellCmd(dir, command string, env []string) *exec.Cmd {
 	cmd := exec.Command("/bin/sh", "-c", command)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	cmd.Dir = dir
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdout = w.outputWriter("build", os.Stdout)
+	cmd.Stderr = w.outputWriter("build", os.Stderr)
+	// The writers above aren't files when output is captured or stripped,
+	// so Wait also waits for the pipe to close; a background child holding
+	// it would otherwise block the build forever.
+	cmd.WaitDelay = 2 * time.Second
+	return cmd
+}
+
+func (w *Watcher) runBuild() error {
+	if w.buildLock != "" {
+		release, err := w.acquireBuildLock()
+		if err != nil {
+			return inStage("lock", err)
+		}
+		defer release()
+	}
+	if w.fullRebuild {
+		if err := w.runClean(); err != nil {
+			return err
+		}
+	}
+	if w.asyncDeps == "" {
+		if err := w.runDeps(); err != nil {
+			return inStage("deps", err)
+		}
+		return w.runBuildSteps(func() error { return nil })
+	}
+	depsDone := w.runDepsAsync()
+	err := w.runBuildSteps(depsDone)
+	// Always waited for, so dep commands never outlive the cycle, and their
+	// failure takes precedence: it's likely why a later step failed too.
+	if depErr := depsDone(); depErr != nil {
+		return inStage("deps", depErr)
+	}
+	return err
 }
 
-func (w *Watcher) runBuild(depChanged bool) error {
-	if depChanged && w.depCmd != "" {
-		log.Printf("%s changed: running %s...\n", w.depFile, w.depCmd)
-		if err := w.runShell(w.depCmd); err != nil {
+// runBuildSteps runs the check, build and --build-step commands the changes
+// since the last successful build concern. depsDone is the barrier for dep
+// commands still running in the background.
+func (w *Watcher) runBuildSteps(depsDone func() error) error {
+	w.buildChanged = w.changedSinceBuild()
+	if w.fullRebuild {
+		// Nothing to go by, so every build step and app is in scope
+		w.buildChanged = nil
+	}
+	if w.ensureDirs {
+		if err := w.ensureOutputDirs(); err != nil {
+			return inStage("build", err)
+		}
+	}
+	return w.runScopedSteps(depsDone)
+}
+
+// runMainBuild runs the check and build commands, waiting at the build for
+// dep commands still running in the background.
+func (w *Watcher) runMainBuild(depsDone func() error) error {
+	if w.checkCmd != "" {
+		log.Println("Running check command...")
+		checkCmd, err := w.expand(w.checkCmd, "check", "", "")
+		if err != nil {
+			return inStage("check", err)
+		}
+		if err := w.runShell(checkCmd); err != nil {
+			return inStage("check", fmt.Errorf("check failed, skipping build: %w", err))
+		}
+	}
+	if w.asyncDeps == "build" {
+		if err := depsDone(); err != nil {
 			return err
 		}
 	}
+	if w.shadowBuild {
+		log.Println("Running build command in a shadow tree...")
+		return inStage("build", w.runShadowBuild(wrapCommand(w.buildWrapper, w.buildCmd), w.goBuildEnv()))
+	}
 	log.Println("Running build command...")
-	return w.runShell(w.buildCmd)
+	return inStage("build", w.runBuildCommand("", wrapCommand(w.buildWrapper, w.buildCmd), w.goBuildEnv()))
+}
+
+// appProcess is one running instance of a run command.
+type appProcess struct {
+	name      string // "app", or "app1", "app2"... with several run commands
+	cmd       *exec.Cmd
+	exited    chan struct{} // closed once the process is reaped
+	tail      *tailBuffer   // last output lines, nil without --crash-tail-lines
+	startTail *tailBuffer   // last stderr lines, kept to tell why a start failed
+	group     bool          // runs in its own process group
+
+	command     string    // the run command, before wrapping and expansion
+	stdin       bool      // attached to the terminal's stdin
+	started     time.Time // when it was started
+	busyRetries int       // starts that hit "text file busy" before this one
+
+	stopping atomic.Bool // set when the watcher stops it, so its exit isn't a crash
+}
+
+// appName names the process for run command i in logs, output and events.
+func (w *Watcher) appName(i int) string {
+	if len(w.runCmds) == 1 {
+		return "app"
+	}
+	return fmt.Sprintf("app%d", i+1)
 }
 
+// startApp (re)starts every run command as its own process. They are stopped
+// and started together; if one fails to start, the others are stopped again.
 func (w *Watcher) startApp() error {
 	w.processMu.Lock()
 	defer w.processMu.Unlock()
 
-	if w.process != nil && w.process.Process != nil {
+	if len(w.processes) > 0 {
 		log.Println("Stopping previous app process...")
-		_ = w.process.Process.Kill()
-		time.Sleep(2 * time.Second)
-		w.process = nil
+		if err := w.stopProcessLocked(); err != nil {
+			return err
+		}
+		w.processes = nil
 	}
 
+	w.section("run")
 	log.Println("Starting app...")
-	cmd := exec.Command("/bin/sh", "-c", w.runCmd)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	for i, runCmd := range w.runCmds {
+		if err := w.startProcessLocked(w.appName(i), runCmd, i == 0); err != nil {
+			if len(w.processes) > 0 {
+				_ = w.stopProcessLocked()
+				w.processes = nil
+			}
+			return fmt.Errorf("starting %s: %w", w.appName(i), err)
+		}
+	}
+	w.appStartedAt.Store(time.Now().UnixNano())
+	return nil
+}
+
+// startProcessLocked starts one run command; the caller holds processMu.
+// Only the first run command gets the terminal's stdin.
+func (w *Watcher) startProcessLocked(name, runCmd string, stdin bool) error {
+	return w.launchLocked(name, runCmd, stdin, 0)
+}
 
+// launchLocked is startProcessLocked for a start that has already hit "text
+// file busy" busyRetries times.
+func (w *Watcher) launchLocked(name, runCmd string, stdin bool, busyRetries int) error {
+	// With a run wrapper the wrapper itself is the process we start and stop;
+	// it is responsible for tearing down the app it launched.
+	command, err := w.expand(wrapCommand(w.runWrapper, runCmd), name, "", "")
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("/bin/sh", "-c", memoryLimited(command, w.runMemory))
+	stdout := os.Stdout
+	if w.appStdout != nil {
+		stdout = w.appStdout
+	}
+	var tail *tailBuffer
+	if w.crashTailLines > 0 {
+		tail = &tailBuffer{max: w.crashTailLines}
+	}
+	cmd.Stdout = w.appOutputWriter(name, stdout, tail)
+	// The shell says why an exec failed only on stderr, so a few lines are
+	// always kept to tell "text file busy" from other failed starts
+	startTail := &tailBuffer{max: startTailLines}
+	stderr := &lineCapture{dst: w.appOutputWriter(name, os.Stderr, tail), strip: true, onLine: startTail.add}
+	cmd.Stderr = stderr
+	// Don't let a background child holding the output pipe block reaping.
+	cmd.WaitDelay = 2 * time.Second
+	if w.attachStdin && stdin {
+		// An *os.File is handed to the child as its own descriptor rather than
+		// copied by a goroutine, so each restarted process reads the terminal
+		// directly and nothing is left consuming stdin after it exits.
+		cmd.Stdin = os.Stdin
+	} else {
+		// Its own group, so stopping it reaches whatever the shell started.
+		// Not with stdin: only the terminal's foreground group may read it.
+		setProcessGroup(cmd)
+	}
+
+	niced(cmd, w.runNice)
 	if err := cmd.Start(); err != nil {
 		return err
 	}
 
-	w.process = cmd
+	proc := &appProcess{
+		name: name, cmd: cmd, exited: make(chan struct{}), tail: tail, startTail: startTail, group: cmd.Stdin == nil,
+		command: runCmd, stdin: stdin, started: time.Now(), busyRetries: busyRetries,
+	}
+	w.processes = append(w.processes, proc)
+	w.emit(Event{Type: AppStarted, App: w.eventApp(name)})
 	go func() {
-		_ = cmd.Wait()
-		log.Println("App exited")
+		err := cmd.Wait()
+		stderr.flush()
+		close(proc.exited)
+		retry := err != nil && !proc.stopping.Load() && busyRetries < textBusyRetries && w.textBusy(proc, err)
+		if retry {
+			log.Printf("%s failed to start: text file busy, the build hadn't released it yet; retrying in %s (%d of %d)",
+				appLabel(name), textBusyDelay, busyRetries+1, textBusyRetries)
+		} else if err != nil && !proc.stopping.Load() {
+			w.recordCrash(proc, err)
+		} else {
+			log.Printf("%s exited", appLabel(name))
+		}
+		w.emit(Event{Type: AppExited, App: w.eventApp(name), Err: err})
 		w.processMu.Lock()
-		w.process = nil
+		w.processes = slices.DeleteFunc(w.processes, func(p *appProcess) bool { return p == proc })
 		w.processMu.Unlock()
+		if retry {
+			w.retryTextBusy(proc)
+		}
 	}()
 	return nil
 }
 
-func (w *Watcher) Run() {
-	for {
-		hash, depChanged, err := w.hashDir()
+// eventApp is the App field of an event about process name: empty when there
+// is only one run command, so single-app events are unchanged.
+func (w *Watcher) eventApp(name string) string {
+	if name == "app" {
+		return ""
+	}
+	return name
+}
+
+func (w *Watcher) appRunning() bool {
+	w.processMu.Lock()
+	defer w.processMu.Unlock()
+	return len(w.processes) > 0
+}
+
+// runBuildWithRetries retries a failed build up to buildRetries times,
+// doubling the wait between attempts, before giving up. The running app is
+// left alone until a build succeeds.
+func (w *Watcher) runBuildWithRetries() error {
+	backoff := w.buildRetryBackoff
+	for attempt := 0; ; attempt++ {
+		// Only the last attempt's errors and warnings count
+		w.diags.reset()
+		err := w.runBuild()
+		if err == nil || errors.Is(err, errNoRestart) || errors.Is(err, errBuildLocked) || attempt >= w.buildRetries {
+			return err
+		}
+		log.Printf("Build failed (attempt %d of %d): %v; retrying in %s", attempt+1, w.buildRetries+1, err, backoff)
+		if !w.sleep(backoff) {
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// rebuild runs the build and, if it succeeds, (re)starts the app, or sends
+// it --reload-signal when only --reload-on files changed. trigger and
+// changes say what set it off. What came of it is recorded for GET
+// /explain as the action: build-skipped, build-failed, left-running,
+// reloaded, not-started, start-failed or restarted.
+func (w *Watcher) rebuild(trigger string, changes changeSet) {
+	w.cycling.Store(true)
+	defer w.cycling.Store(false)
+
+	ex := w.explainRebuild(trigger, changes)
+	defer func() { w.lastExplain.Store(ex) }()
+	w.buildLockPending = false
+	w.gitHeadPending = false
+	w.fullRebuild = w.largeChange(changes)
+	defer func() { w.fullRebuild = false }()
+	if w.fullRebuild {
+		log.Printf("Large change (%d files, more than --large-change-threshold %d): doing a full rebuild", changes.count(), w.largeThreshold)
+		ex.Cause += "; a large change, so a full rebuild"
+	}
+	w.section("build")
+	w.emit(Event{Type: BuildStarted})
+	buildStart := time.Now()
+	stopHeartbeat := w.startHeartbeat()
+	err := w.enforceMaxWarnings(w.runBuildWithRetries())
+	stopHeartbeat()
+	w.recordCycle(trigger, changes.count(), buildStart, err)
+	noRestart := errors.Is(err, errNoRestart)
+	if noRestart {
+		err = nil
+	}
+	errCount, warnCount := w.diags.counts()
+	w.emit(Event{Type: BuildFinished, Err: err, Errors: errCount, Warnings: warnCount})
+	if errors.Is(err, errBuildLocked) {
+		log.Printf("Build skipped: %v; it will run once the watcher is idle", err)
+		w.buildLockPending = true
+		ex.Action, ex.Error = "build-skipped", err.Error()
+		return
+	}
+	if err != nil {
+		log.Println("Build failed:", err)
+		ex.Action, ex.Error = "build-failed", err.Error()
+		logBuildHint(err)
+		if failedStage(err) == "build" {
+			w.logMissingDirHint()
+		}
+		if w.failFast {
+			w.buildErr = err
+			return
+		}
+		w.logNotRestarted(err)
+		return
+	}
+	w.absorbBuildWrites(buildStart, time.Now())
+	w.builtHash = w.prevHash
+	w.builtFiles = w.prevFiles
+	w.saveState()
+
+	if noRestart && w.appRunning() {
+		log.Printf("Build exited with %d (--no-restart-exit-code); leaving the app running", w.noRestartCode)
+		ex.Action = "left-running"
+		return
+	}
+	if !w.fullRebuild && w.reloadable(changes) && w.reloadApp(w.reloadSignal) {
+		ex.Action = "reloaded"
+		return
+	}
+
+	if err := w.checkRunTarget(); err != nil {
+		log.Println(err)
+		ex.Action, ex.Error = "not-started", err.Error()
+		return
+	}
+	if err := w.waitBeforeStart(); err != nil {
+		log.Println("App not started:", err)
+		ex.Action, ex.Error = "not-started", err.Error()
+		return
+	}
+	started, err := w.startAppInScope()
+	if err != nil {
+		log.Println("App start failed:", err)
+		ex.Action, ex.Error = "start-failed", err.Error()
+		return
+	}
+	ex.Action = "left-running"
+	if started {
+		ex.Action = "restarted"
+		w.waitReady()
+	}
+}
+
+// startPrebuilt starts the app from whatever the last build left behind, for
+// --skip-initial-build. A path-style run target that doesn't exist yet can't
+// be started that way, so it is built after all.
+func (w *Watcher) startPrebuilt() {
+	w.processMu.Lock()
+	runCmds := slices.Clone(w.runCmds)
+	w.processMu.Unlock()
+	for i, runCmd := range runCmds {
+		target := w.runTargetOf(w.appName(i), runCmd)
+		if _, err := os.Stat(target); w.checkTarget && target != "" && os.IsNotExist(err) {
+			log.Printf("Run target %s doesn't exist yet, building despite --skip-initial-build...", target)
+			w.rebuild("start", changeSet{})
+			return
+		}
+	}
+	log.Println("Starting app without the initial build (--skip-initial-build)...")
+	w.restart()
+}
+
+// restart restarts the app without rebuilding it.
+func (w *Watcher) restart() {
+	w.cycling.Store(true)
+	defer w.cycling.Store(false)
+
+	if err := w.waitBeforeStart(); err != nil {
+		log.Println("App not restarted:", err)
+		return
+	}
+	if err := w.startApp(); err != nil {
+		log.Println("App restart failed:", err)
+		return
+	}
+	w.waitReady()
+}
+
+// wait sleeps for one polling interval, returning early to handle any
+// trigger that arrives in the meantime, or handles change source events that
+// couldn't queue one.
+func (w *Watcher) wait() {
+	if w.sourceStranded() {
+		w.rebuildFromSource()
+		return
+	}
+	select {
+	case t := <-w.triggers:
+		w.handleTrigger(t)
+	case <-w.stopCh:
+	case <-time.After(w.interval):
+	}
+}
+
+// Run watches for changes until the watcher stops, returning why. A call to
+// Stop yields ErrStopped; see exit.go for the other reasons.
+func (w *Watcher) Run() (err error) {
+	w.started.Store(true)
+	defer close(w.done)
+	defer w.closeEvents()
+	if w.appStdoutPath != "" {
+		// Opened once in append mode and handed to every app process, so
+		// restarts never truncate the file or race a shell redirection.
+		f, err := os.OpenFile(w.appStdoutPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("opening --app-stdout: %w", err)
+		}
+		defer f.Close()
+		w.appStdout = f
+	}
+	defer func() {
+		stopErr := w.stopApp()
+		if stopErr == nil {
+			return
+		}
+		log.Println(stopErr)
+		// A leak found on the way out fails what would otherwise be a
+		// clean end, which is what CI runs under --max-runtime look at.
+		if errors.Is(stopErr, ErrUncleanExit) && slices.ContainsFunc([]error{ErrStopped, ErrIdle, ErrMaxRuntime}, func(e error) bool { return errors.Is(err, e) }) {
+			err = stopErr
+		}
+	}()
+
+	w.checkLimits()
+	w.handleSignals()
+	if w.mux != nil && w.mux.status != nil {
+		// Subscribed here so not even the first build's events are missed
+		go w.statusLoop(w.eventHub.subscribe(sseBuffer))
+	}
+	if w.healthURL != "" {
+		go w.healthLoop()
+	}
+	if w.watchSelf {
+		w.startSelfWatch()
+	}
+	for _, sink := range w.logSinks {
+		// Subscribed here so not even the first build's output is missed
+		done := make(chan struct{})
+		go w.sinkLoop(sink, w.eventHub.subscribe(sinkBuffer), w.output.subscribe(sinkBuffer), done)
+		defer func() {
+			select {
+			case <-done:
+			case <-time.After(sinkFlushEvery):
+			}
+		}()
+	}
+	if w.changeSourceCmd != "" {
+		quit, done := make(chan struct{}), make(chan struct{})
+		go w.changeSourceLoop(quit, done)
+		defer func() {
+			close(quit)
+			select {
+			case <-done:
+			case <-time.After(stopTimeout):
+				log.Println("Timed out waiting for --change-source-cmd to stop")
+			}
+		}()
+	}
+	if w.trackResources {
+		go w.resourceLoop()
+	}
+	if w.httpAddr != "" {
+		go w.serveHTTP(w.httpAddr)
+	}
+
+	if w.loadState() {
+		log.Println("Loaded previous state; unchanged files won't be rebuilt")
+	}
+	defer w.saveState()
+
+	startedAt := time.Now()
+	w.lastActivity = startedAt
+	if len(w.quietWindows) > 0 {
+		spec, in := w.quietPeriod(startedAt)
+		if in {
+			w.enterQuiet(spec)
+		}
+		go w.quietLoop(in)
+	}
+
+	for first := true; !w.stopped(); first = false {
+		if w.buildErr != nil {
+			err := newBuildFailedError(w.buildErr)
+			if err.code > 0 {
+				log.Printf("Stopping on the first build failure (--fail-fast); exiting with the build's exit code %d", err.code)
+			} else {
+				log.Println("Stopping on the first build failure (--fail-fast)")
+			}
+			return err
+		}
+		if err := w.uncleanExitErr(); err != nil {
+			log.Println("Stopping: the app didn't exit cleanly (--verify-clean-exit fail)")
+			return err
+		}
+		if w.maxRuntime > 0 && time.Since(startedAt) >= w.maxRuntime {
+			log.Printf("Stopping after --max-runtime of %s", w.maxRuntime)
+			return ErrMaxRuntime
+		}
+		if w.maxIdle > 0 && time.Since(w.lastActivity) >= w.maxIdle {
+			log.Printf("No changes for %s, stopping (--max-idle)", w.maxIdle)
+			return ErrIdle
+		}
+
+		scanStart := time.Now()
+		scan, err := w.hashDir()
+		if err != nil && first {
+			return fmt.Errorf("%w: %v", ErrInitialScan, err)
+		}
 		if err != nil {
 			log.Println("Error hashing dir:", err)
-			time.Sleep(w.interval)
+			w.wait()
 			continue
 		}
 
-		if hash != w.prevHash {
-			log.Println("Change detected, rebuilding...")
-			w.prevHash = hash
+		if first {
+			w.baselined = true
+			log.Printf("Baseline established: %d files, %d dirs in %s", scan.watched(), scan.dirs, time.Since(scanStart).Round(time.Millisecond))
+			w.debugf("Baseline hash: %x", scan.hash)
+			w.emit(Event{Type: BaselineReady, Files: scan.watched()})
+
+			var ok bool
+			if scan, ok = w.settleStartup(scan); !ok {
+				break
+			}
+		}
+		if first && scan.watched() == 0 && scan.rejected > 0 {
+			log.Printf("WARNING: no files match your include/exclude rules (%d rejected) — nothing will be watched", scan.rejected)
+		}
+
+		touched := w.triggerFileTouched()
+		headMoved := w.gitHeadMoved()
+		if headMoved && w.paused.Load() {
+			log.Println("Paused: git HEAD moved; rebuilding once resumed")
+			w.gitHeadPending = true
+		}
 
-			if err := w.runBuild(depChanged); err != nil {
-				log.Println("Build failed:", err)
-				time.Sleep(w.interval)
-				continue
+		if scan.hash != w.prevHash && w.paused.Load() {
+			changes := diffFiles(w.prevFiles, scan.files)
+			log.Printf("Paused: ignoring %s", changes.summary())
+			w.logChanges(changes)
+			w.prevHash = scan.hash
+			w.prevFiles = scan.files
+			w.changedWhilePaused = true
+		} else if scan.hash != w.prevHash && w.prevFiles != nil && w.suppressedByGrace(diffFiles(w.prevFiles, scan.files)) {
+			w.prevHash = scan.hash
+			w.prevFiles = scan.files
+		} else if first && w.skipInitialBuild {
+			w.prevHash = scan.hash
+			w.prevFiles = scan.files
+			w.startPrebuilt()
+		} else if scan.hash != w.prevHash {
+			var ok bool
+			if scan, ok = w.settle(scan); !ok {
+				break
 			}
+			changes := diffFiles(w.prevFiles, scan.files)
+			if w.dedupeSaves && w.prevFiles != nil && w.sameContent(changes, scan.files) {
+				w.prevHash = scan.hash
+				w.prevFiles = scan.files
+			} else {
+				if w.prevFiles == nil {
+					log.Println("Change detected, rebuilding...")
+				} else {
+					log.Printf("%s; rebuilding...", changes.summary())
+					w.logChanges(changes)
+				}
+				w.emit(Event{Type: ChangeDetected, Changes: changes.count()})
+				w.prevHash = scan.hash
+				w.prevFiles = scan.files
+				w.lastActivity = time.Now()
 
-			if err := w.startApp(); err != nil {
-				log.Println("App start failed:", err)
+				w.rebuild("change", changes)
 			}
+		} else if w.buildLockPending && !w.paused.Load() {
+			log.Println("Retrying the build skipped for --build-lock...")
+			w.rebuild("build-lock", changeSet{})
+		} else if touched {
+			log.Printf("%s touched, rebuilding...", w.triggerFile)
+			w.lastActivity = time.Now()
+			w.rebuild("trigger-file", changeSet{})
+		} else if (headMoved || w.gitHeadPending) && !w.paused.Load() {
+			log.Println("Git HEAD moved, rebuilding...")
+			w.lastActivity = time.Now()
+			w.rebuild("git", changeSet{})
+		} else if first {
+			// The tree matches the state file: the last build is current.
+			log.Println("No changes since last run, starting app without rebuilding...")
+			w.restart()
 		}
 
-		time.Sleep(w.interval)
+		if w.buildErr == nil {
+			w.wait()
+		}
 	}
+	return ErrStopped
 }
 
 func printBanner() {
@@ -196,28 +1142,132 @@ func printBanner() {
 }
 
 func main() {
-	printBanner()
+	if len(os.Args) > 1 && (os.Args[1] == "init" || os.Args[1] == "logs" || os.Args[1] == "checkpoint" || os.Args[1] == "diff") {
+		run := runInit
+		switch os.Args[1] {
+		case "logs":
+			run = runLogs
+		case "checkpoint", "diff":
+			diff := os.Args[1] == "diff"
+			run = func(args []string) error { return runCheckpoint(args, diff) }
+		}
+		err := run(os.Args[2:])
+		if err != nil && err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	cfg := parseConfig()
+	if cfg.stopDaemon {
+		if err := stopDaemon(cfg.pidFile); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if cfg.daemonStatus {
+		os.Exit(daemonStatus(cfg.pidFile))
+	}
+	if cfg.showHistory {
+		if err := printHistory(watcherAddr(cfg.httpAddr)); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if !cfg.listFiles && cfg.manifest == "" {
+		printBanner()
+	}
+	errs := cfg.validate()
+
+	if cfg.checkConfig {
+		cfg.printSummary(os.Stdout)
+		if len(errs) > 0 {
+			for _, err := range errs {
+				fmt.Fprintln(os.Stderr, "error:", err)
+			}
+			os.Exit(exitCode(ErrInvalidConfig))
+		}
+		fmt.Println("configuration OK")
+		return
+	}
+	if len(errs) > 0 {
+		for _, err := range errs {
+			log.Println(err)
+		}
+		os.Exit(exitCode(ErrInvalidConfig))
+	}
+	if cfg.dumpConfig {
+		if err := cfg.saveEffectiveConfig(flag.CommandLine, defaultConfigFile); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	buildCmd := flag.String("build", "echo 'No build command specified'", "Build command to run on change")
-	runCmd := flag.String("run", "echo 'No run command specified'", "Run command to execute built app")
-	depFile := flag.String("depfile", "", "Dependency file to monitor for changes (e.g. go.mod, package.json)")
-	depCmd := flag.String("depcommand", "", "Command to run when dependency file changes (e.g. 'go mod tidy', 'npm install')")
-	interval := flag.Duration("interval", 1*time.Second, "Polling interval (e.g. 1s, 500ms)")
-	includeDirs := flag.String("include", "", "Comma-separated list of include rules (prefix or suffix, e.g. '.go,services')")
-	excludeDirs := flag.String("exclude", "", "Comma-separated list of exclude rules (prefix or suffix, e.g. '.git,tmp')")
+	if cfg.interval < minInterval {
+		log.Printf("Warning: --interval %s is below the %s minimum; polling every %s instead", cfg.interval, minInterval, minInterval)
+	}
+	if cfg.trackResources && !resourceTrackingSupported {
+		log.Println("Warning: --track-resources is not supported on this platform")
+	}
+	if cfg.cleanExit != "off" && !groupTrackingSupported {
+		log.Println("Warning: --verify-clean-exit is not supported on this platform")
+	}
+	if cfg.buildLock != "" && !buildLockSupported {
+		log.Println("Warning: --build-lock is not supported on this platform")
+	}
 
-	flag.Parse()
+	if cfg.daemon && !isDaemonChild() {
+		if err := startDaemon(cfg.pidFile, cfg.logFile); err != nil {
+			log.Println("Error starting daemon:", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	includes := []string{}
-	excludes := []string{}
-	if *includeDirs != "" {
-		includes = strings.Split(*includeDirs, ",")
+	watcher := cfg.newWatcher()
+	if watcher.mux != nil {
+		log.SetOutput(watcher.mux.writer(os.Stderr))
 	}
-	if *excludeDirs != "" {
-		excludes = strings.Split(*excludeDirs, ",")
+
+	if cfg.listFiles {
+		if err := watcher.listFiles(os.Stdout); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+	if cfg.manifest != "" {
+		if err := watcher.runManifest(cfg.manifest, cfg.verifyManifest, os.Stdout); err != nil {
+			log.Println(err)
+			os.Exit(exitCode(err))
+		}
+		return
 	}
 
-	watcher := NewWatcher(".", *interval, *buildCmd, *runCmd, *depFile, *depCmd, includes, excludes)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		log.Printf("Received %s, shutting down...", sig)
+		go func() {
+			sig := <-sigs
+			log.Printf("Received %s again, exiting without waiting for shutdown", sig)
+			os.Exit(1)
+		}()
+		if err := watcher.Stop(); err != nil {
+			log.Println(err)
+		}
+	}()
+
 	log.Println("Starting poly-watcher...")
-	watcher.Run()
+	err := watcher.Run()
+	log.Println("Exiting:", err)
+	if isDaemonChild() {
+		removePIDFile(cfg.pidFile)
+	}
+	os.Exit(exitCode(err))
 }
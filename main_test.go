@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"maps"
+	"slices"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// newTestWatcher returns a watcher whose single root "." is fsys.
+func newTestWatcher(fsys fs.FS, includes, excludes []string) *Watcher {
+	w := NewWatcher([]string{"."}, time.Second, "", "", "", "", includes, excludes)
+	w.openFS = func(string) fs.FS { return fsys }
+	return w
+}
+
+func BenchmarkHashDirExcludedSubtree(b *testing.B) {
+	fsys := fstest.MapFS{}
+	for i := range 50 {
+		fsys[fmt.Sprintf("src/file%d.go", i)] = &fstest.MapFile{Data: []byte("package src")}
+	}
+	for i := range 50000 {
+		fsys[fmt.Sprintf("node_modules/pkg%d/file%d.js", i/100, i)] = &fstest.MapFile{Data: []byte("module.exports = {}")}
+	}
+	w := newTestWatcher(fsys, nil, []string{"node_modules"})
+
+	b.ResetTimer()
+	for range b.N {
+		scan, err := w.hashDir()
+		if err != nil {
+			b.Fatal(err)
+		}
+		// Pruned, node_modules' files are neither watched nor rejected one
+		// by one
+		if len(scan.files) != 50 || scan.rejected != 0 || scan.dirs != 2 {
+			b.Fatalf("got %d files, %d rejected in %d dirs; want 50, 0 in 2", len(scan.files), scan.rejected, scan.dirs)
+		}
+	}
+}
+
+func TestHashDirPrunesUnreachableDirs(t *testing.T) {
+	fsys := fstest.MapFS{
+		"api/gen/v1/types.go": {Data: []byte("package v1")},
+		"api/handler.go":      {Data: []byte("package api")},
+		"docs/index.md":       {Data: []byte("# docs")},
+	}
+	w := newTestWatcher(fsys, []string{"api/gen/"}, nil)
+	scan := scanResult{files: make(map[string]fileState), rejects: make(map[string]string)}
+	if err := w.scanInto(&scan); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := scan.files["api/gen/v1/types.go"]; !ok || len(scan.files) != 1 {
+		t.Errorf("watched %v, want only api/gen/v1/types.go", scan.files)
+	}
+	// api is walked for the include below it, docs isn't
+	if got := scan.rejects["docs/"]; got != "no include can match below it" {
+		t.Errorf("docs/ rejected with %q, want it pruned", got)
+	}
+	if got := scan.rejects["api/handler.go"]; got != "matches no include" {
+		t.Errorf("api/handler.go rejected with %q", got)
+	}
+}
+
+func TestHashModeChmod(t *testing.T) {
+	for _, hashMode := range []bool{false, true} {
+		fsys := fstest.MapFS{"run.sh": {Data: []byte("#!/bin/sh\n"), Mode: 0o644}}
+		w := newTestWatcher(fsys, nil, nil)
+		w.hashMode = hashMode
+		before, err := w.hashDir()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// chmod +x: same content, size and mtime
+		fsys["run.sh"].Mode = 0o755
+		after, err := w.hashDir()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if changed := before.hash != after.hash; changed != hashMode {
+			t.Errorf("hashMode=%v: hash changed = %v", hashMode, changed)
+		}
+		want := []string(nil)
+		if hashMode {
+			want = []string{"run.sh"}
+		}
+		if c := diffFiles(before.files, after.files); !slices.Equal(c.modified, want) || c.count() != len(want) {
+			t.Errorf("hashMode=%v: diffFiles = %+v, want %v modified", hashMode, c, want)
+		}
+	}
+}
+
+func TestRejectReason(t *testing.T) {
+	for _, tt := range []struct {
+		includes, excludes []string
+		path               string
+		want               string
+	}{
+		{nil, nil, "main.go", ""},
+		{[]string{".go"}, nil, "main.go", ""},
+		{[]string{".go"}, nil, "README.md", "matches no include"},
+		{nil, []string{"vendor"}, "vendor/lib/lib.go", `excluded by "vendor"`},
+		// Excludes win over includes
+		{[]string{".go"}, []string{"*_test.go"}, "pkg/a_test.go", `excluded by "*_test.go"`},
+		{[]string{"cmd/"}, []string{"cmd/tools/"}, "cmd/tools/gen.go", `excluded by "cmd/tools/"`},
+		{[]string{"cmd/"}, []string{"cmd/tools/"}, "cmd/api/main.go", ""},
+	} {
+		w := newTestWatcher(nil, tt.includes, tt.excludes)
+		if got := w.rejectReason(tt.path); got != tt.want {
+			t.Errorf("includes %q, excludes %q: rejectReason(%q) = %q, want %q", tt.includes, tt.excludes, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestHashDir(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.go":           {Data: []byte("package main")},
+		"main_test.go":      {Data: []byte("package main")},
+		"README.md":         {Data: []byte("# app")},
+		".env":              {Data: []byte("DEBUG=1")},
+		".git/HEAD":         {Data: []byte("ref: refs/heads/main")},
+		"web/.cache/x.js":   {Data: []byte("x")},
+		"web/app.ts":        {Data: []byte("export {}")},
+		"vendor/lib/lib.go": {Data: []byte("package lib")},
+	}
+	for _, tt := range []struct {
+		name               string
+		includes, excludes []string
+		want               []string
+	}{
+		{"everything", nil, nil, []string{".env", "README.md", "main.go", "main_test.go", "vendor/lib/lib.go", "web/app.ts"}},
+		{"includes", []string{".go", "web/"}, nil, []string{"main.go", "main_test.go", "vendor/lib/lib.go", "web/app.ts"}},
+		{"excludes", nil, []string{"vendor", ".md"}, []string{".env", "main.go", "main_test.go", "web/app.ts"}},
+		{"excludes win", []string{".go"}, []string{"*_test.go", "vendor"}, []string{"main.go"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			scan, err := newTestWatcher(fsys, tt.includes, tt.excludes).hashDir()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Hidden directories are skipped, hidden files aren't
+			if got := slices.Sorted(maps.Keys(scan.files)); !slices.Equal(got, tt.want) {
+				t.Errorf("watched %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashDirStable(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.go":     {Data: []byte("package a"), ModTime: time.Unix(1000, 0)},
+		"sub/b.go": {Data: []byte("package sub"), ModTime: time.Unix(1000, 0)},
+	}
+	w := newTestWatcher(fsys, nil, nil)
+	first, err := w.hashDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := w.hashDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.hash != second.hash {
+		t.Error("hash changed between two scans of an unchanged tree")
+	}
+	if c := diffFiles(first.files, second.files); c.count() != 0 {
+		t.Errorf("unchanged tree diffs as %s", c.summary())
+	}
+
+	fsys["sub/b.go"] = &fstest.MapFile{Data: []byte("package sub"), ModTime: time.Unix(2000, 0)}
+	third, err := w.hashDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.hash == second.hash {
+		t.Error("hash unchanged after a save")
+	}
+}
+
+func TestHashDirTracksDepFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"go.mod":            {Data: []byte("module a"), ModTime: time.Unix(1000, 0)},
+		"tools/go.mod":      {Data: []byte("module tools"), ModTime: time.Unix(1000, 0)},
+		"web/package.json":  {Data: []byte("{}"), ModTime: time.Unix(1000, 0)},
+		"web/package.json5": {Data: []byte("{}"), ModTime: time.Unix(1000, 0)},
+	}
+	w := newTestWatcher(fsys, nil, nil)
+	w.depRules = []depRule{{file: "go.mod", command: "go mod download"}, {file: "package.json", command: "npm ci"}}
+	if _, err := w.hashDir(); err != nil {
+		t.Fatal(err)
+	}
+	// Dep files are found by base name anywhere in the tree, and are all
+	// pending until their command has run
+	want := map[int][]string{0: {"go.mod", "tools/go.mod"}, 1: {"web/package.json"}}
+	got := w.changedDeps()
+	for rule := range got {
+		slices.Sort(got[rule])
+	}
+	if !maps.EqualFunc(got, want, slices.Equal) {
+		t.Fatalf("changedDeps() = %v, want %v", got, want)
+	}
+	w.commitDeps(append(got[0], got[1]...))
+
+	// Touching go.mod leaves it handled; editing package.json doesn't
+	fsys["go.mod"] = &fstest.MapFile{Data: []byte("module a"), ModTime: time.Unix(2000, 0)}
+	fsys["web/package.json"] = &fstest.MapFile{Data: []byte(`{"name": "web"}`), ModTime: time.Unix(2000, 0)}
+	if _, err := w.hashDir(); err != nil {
+		t.Fatal(err)
+	}
+	want = map[int][]string{1: {"web/package.json"}}
+	if got := w.changedDeps(); !maps.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("after an edit, changedDeps() = %v, want %v", got, want)
+	}
+}
+
+func TestHashDirMaxFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.go": {Data: []byte("package a")},
+		"b.go": {Data: []byte("package a")},
+	}
+	w := newTestWatcher(fsys, nil, nil)
+	w.maxFiles = 2
+	if _, err := w.hashDir(); err != nil {
+		t.Fatalf("hashDir at the limit: %v", err)
+	}
+	fsys["c.go"] = &fstest.MapFile{Data: []byte("package a")}
+	if _, err := w.hashDir(); err == nil {
+		t.Fatal("first scan over --max-files succeeded")
+	}
+	// Directories aren't files, even with --hash-dirs
+	w.hashDirs = true
+	delete(fsys, "c.go")
+	fsys["pkg/d"] = &fstest.MapFile{Mode: fs.ModeDir}
+	if _, err := w.hashDir(); err != nil {
+		t.Fatalf("hashDir with a directory at the limit: %v", err)
+	}
+	fsys["c.go"] = &fstest.MapFile{Data: []byte("package a")}
+	// Once watching, growing past the limit only warns
+	w.baselined = true
+	scan, err := w.hashDir()
+	if err != nil {
+		t.Fatalf("later scan over --max-files: %v", err)
+	}
+	if scan.watched() != 3 {
+		t.Errorf("watched %d files, want 3", scan.watched())
+	}
+}
+
+func TestDebounceWindowRelativeToRoot(t *testing.T) {
+	w := NewWatcher([]string{"services/api"}, time.Second, "", "", "", "", nil, nil)
+	w.debounce = 100 * time.Millisecond
+	for _, s := range []string{"web/*.js=2s", "*.css=1s"} {
+		r, err := parseDebounceRule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.debounceRules = append(w.debounceRules, r)
+	}
+	for _, tt := range []struct {
+		path string
+		want time.Duration
+	}{
+		{"services/api/web/app.js", 2 * time.Second},
+		{"services/api/web/site.css", time.Second},
+		{"services/api/main.go", 100 * time.Millisecond},
+		{"services/api/cmd/web/app.js", 100 * time.Millisecond},
+	} {
+		if got := w.debounceWindow(changeSet{modified: []string{tt.path}}); got != tt.want {
+			t.Errorf("debounceWindow(%s) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// manifest maps each watched file, as a slash-separated path, to the
+// sha256 of its content.
+type manifest map[string]string
+
+// buildManifest scans the roots once and hashes the content of every
+// watched file. Directory entries from --hash-dirs have no content and are
+// left out.
+func (w *Watcher) buildManifest() (manifest, error) {
+	scan := scanResult{files: make(map[string]fileState)}
+	if err := w.scanInto(&scan); err != nil {
+		return nil, err
+	}
+	m := make(manifest, len(scan.files))
+	for name := range scan.files {
+		if strings.HasSuffix(name, string(filepath.Separator)) {
+			continue
+		}
+		sum, err := hashFileContent(os.DirFS(filepath.Dir(name)), filepath.Base(name))
+		if err != nil {
+			return nil, fmt.Errorf("hashing %s: %w", name, err)
+		}
+		m[filepath.ToSlash(name)] = sum
+	}
+	return m, nil
+}
+
+// writeTo writes m in sha256sum's format, "<hex>  <path>" sorted by path,
+// so `sha256sum -c` can check it too.
+func (m manifest) writeTo(out io.Writer) error {
+	bw := bufio.NewWriter(out)
+	for _, name := range sortedKeys(m) {
+		fmt.Fprintf(bw, "%s  %s\n", m[name], name)
+	}
+	return bw.Flush()
+}
+
+func readManifest(path string) (manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := make(manifest)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sum, name, ok := strings.Cut(line, "  ")
+		if !ok || len(sum) != 64 || name == "" {
+			return nil, fmt.Errorf("%s:%d: want \"<sha256>  <path>\"", path, n)
+		}
+		m[name] = sum
+	}
+	return m, sc.Err()
+}
+
+// ErrManifestDrift means --manifest-verify found the tree differs from the
+// manifest. (exit 6)
+var ErrManifestDrift = errors.New("tree differs from manifest")
+
+// runManifest writes the --manifest file, or with --manifest-verify checks
+// the tree against it and reports every added, modified and deleted file.
+func (w *Watcher) runManifest(path string, verify bool, out io.Writer) error {
+	cur, err := w.buildManifest()
+	if err != nil {
+		return err
+	}
+	if !verify {
+		var b strings.Builder
+		if err := cur.writeTo(&b); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Wrote %d files to %s\n", len(cur), path)
+		return nil
+	}
+
+	want, err := readManifest(path)
+	if err != nil {
+		return fmt.Errorf("reading manifest: %w", err)
+	}
+	drift := 0
+	for _, name := range sortedKeys(cur) {
+		sum, ok := want[name]
+		switch {
+		case !ok:
+			fmt.Fprintf(out, "added     %s\n", name)
+		case sum != cur[name]:
+			fmt.Fprintf(out, "modified  %s\n", name)
+		default:
+			continue
+		}
+		drift++
+	}
+	for _, name := range sortedKeys(want) {
+		if _, ok := cur[name]; !ok {
+			fmt.Fprintf(out, "deleted   %s\n", name)
+			drift++
+		}
+	}
+	if drift > 0 {
+		return fmt.Errorf("%w %s (%d files differ)", ErrManifestDrift, path, drift)
+	}
+	fmt.Fprintf(out, "All %d files match %s\n", len(cur), path)
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// sectionMarkers writes a "── build ──" or "── run ──" rule to stdout as
+// each phase of a cycle starts, through the logMux so it lands in order
+// with the phase's logs and output.
+type sectionMarkers struct {
+	out      io.Writer
+	terminal bool // stdout is a terminal: full-width and dimmed
+}
+
+// Section marker modes for --section-markers.
+const (
+	sectionAuto   = "auto"   // only when stdout is a terminal
+	sectionAlways = "always" // plain ASCII when stdout isn't a terminal
+	sectionNever  = "never"  // no markers
+)
+
+func validSectionMode(mode string) error {
+	switch mode {
+	case sectionAuto, sectionAlways, sectionNever:
+		return nil
+	}
+	return fmt.Errorf("invalid --section-markers %q (want auto, always or never)", mode)
+}
+
+// newSectionMarkers returns the markers for --section-markers mode, or nil
+// when they are off: never, or auto with stdout not a terminal.
+func newSectionMarkers(mode string, mux *logMux) *sectionMarkers {
+	terminal := isTerminal(os.Stdout)
+	if mode == sectionNever || (mode == sectionAuto && !terminal) {
+		return nil
+	}
+	return &sectionMarkers{out: mux.writer(os.Stdout), terminal: terminal}
+}
+
+// line renders the marker for phase; piped output gets plain ASCII.
+func (s *sectionMarkers) line(phase string) string {
+	if !s.terminal {
+		return "-- " + phase + " --"
+	}
+	rule := "── " + phase + " "
+	if width := terminalWidth(os.Stdout); width > len(phase)+4 {
+		rule += strings.Repeat("─", width-len(phase)-4)
+	} else {
+		rule += "──"
+	}
+	return "\x1b[2m" + rule + "\x1b[0m"
+}
+
+// section marks the start of phase, with --section-markers.
+func (w *Watcher) section(phase string) {
+	if w.sections != nil {
+		fmt.Fprintln(w.sections.out, w.sections.line(phase))
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// matchRule reports whether an include or exclude rule matches relPath.
+//
+// Rules containing a "/" are path rules, anchored at the root: a glob if they
+// contain *, ? or [ ("cmd/*/main.go"), otherwise a prefix of the relative
+// path ("cmd/server", "web/static/"). Rules without a "/" are name rules,
+// matched against each path element so they behave the same at any depth:
+//
+//   - a glob matches an element ("*_test.go", "Dockerfile.*")
+//   - a rule starting with "." matches the end of the file name (".go", ".pb.go")
+//   - anything else must equal an element exactly, so "Makefile" matches
+//     a/Makefile but not GNUMakefile, and "vendor" matches everything under
+//     any vendor directory
+func matchRule(rule, relPath string) bool {
+	p := filepath.ToSlash(relPath)
+	glob := strings.ContainsAny(rule, "*?[")
+	if strings.Contains(rule, "/") {
+		if glob {
+			ok, _ := path.Match(rule, p)
+			return ok
+		}
+		return strings.HasPrefix(p, rule)
+	}
+
+	if !glob && strings.HasPrefix(rule, ".") && strings.HasSuffix(path.Base(p), rule) {
+		return true
+	}
+	for _, elem := range strings.Split(p, "/") {
+		if glob {
+			if ok, _ := path.Match(rule, elem); ok {
+				return true
+			}
+		} else if elem == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// legacyMatch is how rules matched before name rules: as a prefix or suffix
+// of the relative path, so "_test.go" matched foo_test.go and "services"
+// matched services2/.
+func legacyMatch(rule, relPath string) bool {
+	p := filepath.ToSlash(relPath)
+	return strings.HasPrefix(p, rule) || strings.HasSuffix(p, rule)
+}
+
+// legacyWarnings logs, once per rule, that a rule no longer matches a path
+// it used to.
+type legacyWarnings struct {
+	mu     sync.Mutex
+	warned map[string]bool
+}
+
+func (l *legacyWarnings) warn(flag, rule, relPath string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := flag + " " + rule
+	if l.warned[key] {
+		return
+	}
+	if l.warned == nil {
+		l.warned = make(map[string]bool)
+	}
+	l.warned[key] = true
+
+	var fix string
+	switch {
+	case strings.Contains(rule, "/"):
+		fix = "path rules are anchored at the root"
+	case strings.HasSuffix(filepath.ToSlash(relPath), rule):
+		fix = fmt.Sprintf("write %q to match the end of a name", "*"+rule)
+	default:
+		fix = fmt.Sprintf("write %q to match the start of a name", rule+"*")
+	}
+	log.Printf("WARNING: --%s %q used to match %s but no longer does: %s", flag, rule, relPath, fix)
+}
+
+// ruleMatches is matchRule for a rule of the --include or --exclude flag,
+// warning when the rule only matches relPath the way rules used to.
+func (w *Watcher) ruleMatches(flag, rule, relPath string) bool {
+	if matchRule(rule, relPath) {
+		return true
+	}
+	if legacyMatch(rule, relPath) {
+		w.legacyRules.warn(flag, rule, relPath)
+	}
+	return false
+}
+
+// mayContainIncluded reports whether some file under directory relDir could
+// match an include rule. Only path rules can rule a directory out; with any
+// name rule, or no includes at all, every directory is walked.
+func (w *Watcher) mayContainIncluded(relDir string) bool {
+	if len(w.includes) == 0 {
+		return true
+	}
+	dir := filepath.ToSlash(relDir) + "/"
+	for _, rule := range w.includes {
+		if !strings.Contains(rule, "/") {
+			return true
+		}
+		// Everything up to the first glob character must be a literal prefix
+		lit := rule
+		if i := strings.IndexAny(rule, "*?["); i >= 0 {
+			lit = rule[:i]
+		}
+		if strings.HasPrefix(lit, dir) || strings.HasPrefix(dir, lit) {
+			return true
+		}
+	}
+	return false
+}
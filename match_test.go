@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestMatchRule(t *testing.T) {
+	for _, tt := range []struct {
+		rule, path string
+		want       bool
+	}{
+		{"*_test.go", "pkg/foo_test.go", true},
+		{"_test.go", "pkg/foo_test.go", false}, // was a suffix match
+		{".go", "pkg/foo.go", true},
+		{".pb.go", "api/foo.pb.go", true},
+		{"Makefile", "a/Makefile", true},
+		{"Makefile", "GNUMakefile", false},
+		{"vendor", "a/vendor/b/c.go", true},
+		{"services", "services/api.go", true},
+		{"services", "services2/api.go", false}, // was a prefix match
+		{"cmd/server", "cmd/server/main.go", true},
+		{"cmd/server", "cmd/server2/main.go", true}, // path rules are still prefixes
+		{"cmd/*/main.go", "cmd/api/main.go", true},
+		{"cmd/*/main.go", "cmd/api/util.go", false},
+		{"Dockerfile.*", "deploy/Dockerfile.prod", true},
+	} {
+		if got := matchRule(tt.rule, tt.path); got != tt.want {
+			t.Errorf("matchRule(%q, %q) = %v, want %v", tt.rule, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestLegacyMatch(t *testing.T) {
+	for _, tt := range []struct {
+		rule, path string
+		want       bool
+	}{
+		{"_test.go", "pkg/foo_test.go", true},
+		{"services", "services2/api.go", true},
+		{"GNUMakefile", "GNUMakefile", true},
+		{"vendor", "a/vendor/b/c.go", false},
+	} {
+		if got := legacyMatch(tt.rule, tt.path); got != tt.want {
+			t.Errorf("legacyMatch(%q, %q) = %v, want %v", tt.rule, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRejectReasonWarnsOnLegacyMatch(t *testing.T) {
+	var buf bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(prev)
+
+	w := newTestWatcher(nil, nil, []string{"services"})
+	for _, p := range []string{"services2/api.go", "services2/db.go", "main.go"} {
+		if reason := w.rejectReason(p); reason != "" {
+			t.Errorf("rejectReason(%q) = %q, want it watched", p, reason)
+		}
+	}
+	w = newTestWatcher(nil, []string{"_test.go"}, nil)
+	for _, p := range []string{"pkg/a_test.go", "pkg/b_test.go", "main.go"} {
+		if reason := w.rejectReason(p); reason != "matches no include" {
+			t.Errorf("rejectReason(%q) = %q, want it rejected", p, reason)
+		}
+	}
+
+	out := buf.String()
+	for _, want := range []string{
+		`--exclude "services" used to match services2/api.go but no longer does: write "services*" to match the start of a name`,
+		`--include "_test.go" used to match pkg/a_test.go but no longer does: write "*_test.go" to match the end of a name`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing warning %q in log:\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, "WARNING"); n != 2 {
+		t.Errorf("got %d warnings, want one per rule:\n%s", n, out)
+	}
+}
+
+func TestMayContainIncluded(t *testing.T) {
+	for _, tt := range []struct {
+		includes []string
+		dir      string
+		want     bool
+	}{
+		{nil, "docs", true},
+		// Name rules can match at any depth
+		{[]string{".go"}, "docs", true},
+		{[]string{"web/", "Makefile"}, "docs", true},
+		// Path rules
+		{[]string{"web/"}, "web", true},
+		{[]string{"web/"}, "web/src", true},
+		{[]string{"web/"}, "docs", false},
+		{[]string{"web/"}, "webapp", false},
+		{[]string{"cmd/server"}, "cmd/server2", true}, // a prefix, not a directory
+		// Globbed path rules, up to the first glob character
+		{[]string{"cmd/*/main.go"}, "cmd", true},
+		{[]string{"cmd/*/main.go"}, "cmd/api", true},
+		{[]string{"cmd/*/main.go"}, "internal", false},
+		{[]string{"web/*.ts"}, "web/src", true}, // pruned by matchRule instead
+		// A directory only a deeper include reaches
+		{[]string{"api/gen/v1/"}, "api", true},
+		{[]string{"api/gen/v1/"}, "api/gen", true},
+		{[]string{"api/gen/v1/"}, "api/other", false},
+		{[]string{"docs/", "api/gen/v1/"}, "api/gen", true},
+	} {
+		w := newTestWatcher(nil, tt.includes, nil)
+		if got := w.mayContainIncluded(tt.dir); got != tt.want {
+			t.Errorf("includes %q: mayContainIncluded(%q) = %v, want %v", tt.includes, tt.dir, got, tt.want)
+		}
+	}
+}
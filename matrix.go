@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// matrixEntry is one variant of a matrix build: the environment the build
+// command runs with (e.g. GOOS=linux GOARCH=arm64).
+type matrixEntry struct {
+	env []string
+}
+
+// label names the entry in logs and on its output lines.
+func (e matrixEntry) label() string {
+	return strings.Join(e.env, ",")
+}
+
+func parseMatrixEntry(s string) (matrixEntry, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return matrixEntry{}, fmt.Errorf("invalid --build-matrix %q, want space-separated NAME=value pairs", s)
+	}
+	for _, f := range fields {
+		if name, _, ok := strings.Cut(f, "="); !ok || name == "" {
+			return matrixEntry{}, fmt.Errorf("invalid --build-matrix %q: %q is not NAME=value", s, f)
+		}
+	}
+	return matrixEntry{env: fields}, nil
+}
+
+// runBuildCommand runs the build command from dir with env added, once per
+// matrix entry when a matrix is configured.
+func (w *Watcher) runBuildCommand(dir, command string, env []string) error {
+	if len(w.buildMatrix) == 0 {
+		if command == "" {
+			return nil
+		}
+		command, err := w.expand(command, "build", dir, "")
+		if err != nil {
+			return err
+		}
+		return w.runJudged(w.shellCmd(dir, command, env))
+	}
+	return w.runMatrix(dir, command, env)
+}
+
+// runMatrix runs command once per matrix entry with the entry's environment
+// added, at most buildParallelism at a time. Every entry runs even if one
+// fails; the build fails if any entry did.
+func (w *Watcher) runMatrix(dir, command string, env []string) error {
+	sem := make(chan struct{}, w.buildParallelism)
+	errs := make([]error, len(w.buildMatrix))
+	var wg sync.WaitGroup
+	for i, entry := range w.buildMatrix {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			start := time.Now()
+			if err := w.runMatrixEntry(dir, command, append(slices.Clip(env), entry.env...), entry); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", entry.label(), err)
+				return
+			}
+			w.debugf("Build for %s finished in %s", entry.label(), time.Since(start).Round(time.Millisecond))
+		}()
+	}
+	wg.Wait()
+
+	// The app is left running only if every entry asked for that
+	noRestart := true
+	for i, err := range errs {
+		if errors.Is(err, errNoRestart) {
+			errs[i] = nil
+		} else {
+			noRestart = false
+		}
+	}
+	err := errors.Join(errs...)
+	if err != nil {
+		return err
+	}
+	log.Printf("Built %d matrix entries", len(w.buildMatrix))
+	if noRestart {
+		return errNoRestart
+	}
+	return nil
+}
+
+func (w *Watcher) runMatrixEntry(dir, command string, env []string, entry matrixEntry) error {
+	command, err := w.expand(command, "build", dir, entry.label())
+	if err != nil {
+		return err
+	}
+	prefix := []byte("[" + entry.label() + "] ")
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = &prefixWriter{dst: w.outputWriter("build", os.Stdout), prefix: prefix}
+	cmd.Stderr = &prefixWriter{dst: w.outputWriter("build", os.Stderr), prefix: prefix}
+	cmd.WaitDelay = 2 * time.Second
+	return w.runJudged(cmd)
+}
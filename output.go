@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// outputLine is one line of build or app output.
+type outputLine struct {
+	Source string    `json:"source"` // "build", "app", or "app1"... with several run commands
+	Stream string    `json:"stream"` // "stdout" or "stderr"
+	Time   time.Time `json:"time"`
+	Line   string    `json:"line"`
+}
+
+// lineCapture passes output through to dst unchanged and hands each
+// complete line, without its line ending or escape sequences, to onLine.
+type lineCapture struct {
+	dst    io.Writer
+	strip  bool
+	onLine func(line string)
+
+	mu      sync.Mutex
+	partial []byte
+}
+
+func (c *lineCapture) Write(p []byte) (int, error) {
+	n, err := c.dst.Write(p)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.partial = append(c.partial, p...)
+	for {
+		i := bytes.IndexByte(c.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(bytes.TrimSuffix(c.partial[:i], []byte("\r")))
+		c.partial = c.partial[i+1:]
+		if c.strip {
+			line = stripANSI(line)
+		}
+		c.onLine(line)
+	}
+	return n, err
+}
+
+// flush hands a final line without a trailing newline to onLine.
+func (c *lineCapture) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.partial) == 0 {
+		return
+	}
+	line := string(c.partial)
+	c.partial = nil
+	if c.strip {
+		line = stripANSI(line)
+	}
+	c.onLine(line)
+}
+
+// prefixWriter starts every line written to dst with prefix, so output from
+// several app processes sharing a terminal can be told apart.
+type prefixWriter struct {
+	dst    io.Writer
+	prefix []byte
+
+	mu      sync.Mutex
+	midLine bool
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := len(b)
+	var buf []byte
+	for len(b) > 0 {
+		if !p.midLine {
+			buf = append(buf, p.prefix...)
+		}
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			buf = append(buf, b...)
+			p.midLine = true
+			break
+		}
+		buf = append(buf, b[:i+1]...)
+		b = b[i+1:]
+		p.midLine = false
+	}
+	if _, err := p.dst.Write(buf); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// captureOutput reports whether child output must be intercepted. Otherwise
+// children write straight to the terminal.
+func (w *Watcher) captureOutput() bool {
+	return w.httpAddr != "" || len(w.logSinks) > 0
+}
+
+// outputWriter returns the writer for a child's stdout or stderr. Escape
+// sequences are stripped for the HTTP log stream, and for dst itself when
+// it isn't a terminal, unless --strip-ansi says otherwise. With
+// --serialize-output it goes through the watcher's logMux.
+func (w *Watcher) outputWriter(source string, dst *os.File) io.Writer {
+	var out io.Writer = dst
+	if w.mux != nil {
+		out = w.mux.writer(dst)
+	}
+	if w.stripFor(dst) {
+		out = &ansiWriter{dst: out}
+	}
+	if source != "build" && source != "app" {
+		out = &prefixWriter{dst: out, prefix: []byte("[" + source + "] ")}
+	}
+	if !w.captureOutput() {
+		return out
+	}
+	stream := "stdout"
+	if dst == os.Stderr {
+		stream = "stderr"
+	}
+	return &lineCapture{dst: out, strip: w.stripANSI != stripNever, onLine: func(line string) {
+		w.output.publish(outputLine{Source: source, Stream: stream, Time: time.Now(), Line: line})
+	}}
+}
+
+// tailBuffer keeps the last few lines of an app's output, so they can be
+// shown when it crashes.
+type tailBuffer struct {
+	mu    sync.Mutex
+	max   int
+	lines []string
+}
+
+func (t *tailBuffer) add(line string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.lines) == t.max {
+		t.lines = append(t.lines[:0], t.lines[1:]...)
+	}
+	t.lines = append(t.lines, line)
+}
+
+func (t *tailBuffer) get() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Clone(t.lines)
+}
+
+// appOutputWriter is outputWriter for an app process that also records its
+// output lines in tail, when one is kept.
+func (w *Watcher) appOutputWriter(name string, dst *os.File, tail *tailBuffer) io.Writer {
+	out := w.outputWriter(name, dst)
+	if tail == nil {
+		return out
+	}
+	return &lineCapture{dst: out, strip: true, onLine: tail.add}
+}
@@ -0,0 +1,53 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const groupTrackingSupported = true
+
+// setProcessGroup starts cmd in a process group of its own, so signalGroup
+// reaches everything the shell started, not just the shell.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func signalGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	return syscall.Kill(-cmd.Process.Pid, sig)
+}
+
+// groupAlive reports whether any process is left in process group pgid.
+func groupAlive(pgid int) bool {
+	err := syscall.Kill(-pgid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// groupMembers lists the live processes in process group pgid, using ps so
+// it works the same on Linux and macOS. Zombies are left out: they're dead,
+// merely not reaped yet by whatever inherited them.
+func groupMembers(pgid int) ([]groupMember, error) {
+	out, err := exec.Command("ps", "-A", "-o", "pid=", "-o", "pgid=", "-o", "stat=", "-o", "args=").Output()
+	if err != nil {
+		return nil, err
+	}
+	var members []groupMember
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || strings.HasPrefix(fields[2], "Z") {
+			continue
+		}
+		pid, err1 := strconv.Atoi(fields[0])
+		group, err2 := strconv.Atoi(fields[1])
+		if err1 != nil || err2 != nil || group != pgid {
+			continue
+		}
+		members = append(members, groupMember{pid: pid, command: strings.Join(fields[3:], " ")})
+	}
+	return members, nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup is a no-op on Windows, which has no process groups to
+// signal.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalGroup can only kill the process itself on Windows; other signals
+// fail.
+func signalGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	if sig == syscall.SIGKILL {
+		return cmd.Process.Kill()
+	}
+	return cmd.Process.Signal(sig)
+}
+
+const groupTrackingSupported = false
+
+func groupAlive(pgid int) bool { return false }
+
+func groupMembers(pgid int) ([]groupMember, error) {
+	return nil, errors.New("process groups are not supported on Windows")
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// quietWindow is one --quiet-schedule entry: a daily time range, on some
+// weekdays or all of them. A range whose end is before its start runs past
+// midnight into the next day.
+type quietWindow struct {
+	spec       string
+	days       [7]bool // indexed by time.Weekday
+	start, end int     // minutes since midnight; end may be 24*60
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseQuietWindow parses "[days ]HH:MM-HH:MM", where days is a weekday
+// (Mon), a range (Mon-Fri) or a list (Sat,Sun), e.g. "Mon-Fri 14:00-15:30".
+func parseQuietWindow(spec string) (quietWindow, error) {
+	q := quietWindow{spec: spec}
+	fields := strings.Fields(spec)
+	var days, times string
+	switch len(fields) {
+	case 1:
+		times = fields[0]
+		q.days = [7]bool{true, true, true, true, true, true, true}
+	case 2:
+		days, times = fields[0], fields[1]
+	default:
+		return q, fmt.Errorf("invalid --quiet-schedule %q, want e.g. 'Mon-Fri 09:00-17:00' or '12:00-13:00'", spec)
+	}
+
+	for _, part := range strings.Split(days, ",") {
+		if part == "" && days == "" {
+			break
+		}
+		from, to, isRange := strings.Cut(strings.ToLower(part), "-")
+		first, ok1 := weekdays[from]
+		last, ok2 := weekdays[to]
+		if !isRange {
+			last, ok2 = first, ok1
+		}
+		if !ok1 || !ok2 {
+			return q, fmt.Errorf("invalid --quiet-schedule %q: unknown day %q (want Mon, Tue, ... or a range like Mon-Fri)", spec, part)
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			q.days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+
+	from, to, ok := strings.Cut(times, "-")
+	var err error
+	if q.start, err = parseClock(from, false); ok && err == nil {
+		q.end, err = parseClock(to, true)
+	}
+	if !ok || err != nil {
+		return q, fmt.Errorf("invalid --quiet-schedule %q: want a time range like 09:00-17:00", spec)
+	}
+	if q.start == q.end {
+		return q, fmt.Errorf("invalid --quiet-schedule %q: empty time range", spec)
+	}
+	return q, nil
+}
+
+// parseClock parses HH:MM into minutes since midnight; 24:00 is allowed as
+// an end time.
+func parseClock(s string, end bool) (int, error) {
+	var h, m int
+	if n, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || n != 2 || len(s) != 5 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	if (h == 24 && m == 0 && end) || (h >= 0 && h < 24 && m >= 0 && m < 60) {
+		return h*60 + m, nil
+	}
+	return 0, fmt.Errorf("invalid time %q", s)
+}
+
+func (q quietWindow) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if q.start < q.end {
+		return q.days[day] && m >= q.start && m < q.end
+	}
+	// Past midnight: the evening part is on a listed day, the morning part
+	// on the day after one
+	return (q.days[day] && m >= q.start) || (q.days[(day+6)%7] && m < q.end)
+}
+
+// quietPeriod returns the --quiet-schedule window t falls in, if any.
+func (w *Watcher) quietPeriod(t time.Time) (string, bool) {
+	t = t.In(w.quietTZ)
+	for _, q := range w.quietWindows {
+		if q.contains(t) {
+			return q.spec, true
+		}
+	}
+	return "", false
+}
+
+// quietCheckInterval is how often the schedule is checked; windows are set
+// in whole minutes.
+const quietCheckInterval = 15 * time.Second
+
+// quietLoop pauses the watcher for each --quiet-schedule window through the
+// run loop's triggers and resumes it afterwards. in says whether the watcher
+// started inside a window.
+func (w *Watcher) quietLoop(in bool) {
+	for w.sleep(quietCheckInterval) {
+		_, now := w.quietPeriod(time.Now())
+		if now == in {
+			continue
+		}
+		t := triggerQuietEnd
+		if now {
+			t = triggerQuietStart
+		}
+		// Not enqueue, which drops a trigger when the queue is full: a lost
+		// quiet-end would leave the watcher paused until the next window
+		// ended
+		select {
+		case w.triggers <- t:
+			in = now
+		case <-w.stopCh:
+			return
+		}
+	}
+}
+
+// enterQuiet pauses the watcher for a quiet period, remembering that the
+// schedule rather than the user paused it.
+func (w *Watcher) enterQuiet(spec string) {
+	if w.paused.Swap(true) {
+		log.Printf("Quiet period %q started; already paused", spec)
+		return
+	}
+	w.quietPaused = true
+	w.changedWhilePaused = false
+	log.Printf("Quiet period %q started: changes are recorded but not built until it ends", spec)
+}
+
+// leaveQuiet resumes the watcher after a quiet period it paused, building
+// whatever changed meanwhile.
+func (w *Watcher) leaveQuiet() {
+	if !w.quietPaused {
+		return
+	}
+	w.quietPaused = false
+	w.paused.Store(false)
+	log.Println("Quiet period over, watching again")
+	if w.changedWhilePaused {
+		log.Println("Files changed during the quiet period, rebuilding...")
+		w.rebuild("quiet-end", changeSet{})
+	}
+}
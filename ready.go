@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// waitReady runs --ready-cmd after the app starts until it exits 0, the app
+// exits, or --ready-timeout elapses, and emits AppReady once it succeeds. A
+// timeout is logged but leaves the app running. An attempt still running at
+// the timeout, or when the watcher stops, is killed.
+func (w *Watcher) waitReady() {
+	if w.readyCmd == "" {
+		return
+	}
+	start := time.Now()
+	ctx, cancel := w.stopContext(w.readyTimeout)
+	defer cancel()
+	for attempt := 1; ; attempt++ {
+		err := groupCommand(ctx, w.readyCmd).Run()
+		if err == nil {
+			log.Printf("App is ready after %s", time.Since(start).Round(time.Millisecond))
+			w.emit(Event{Type: AppReady})
+			return
+		}
+		w.debugf("Readiness check %d failed: %v", attempt, err)
+		if !w.appRunning() {
+			log.Println("App exited before it became ready")
+			return
+		}
+		if w.stopped() {
+			return
+		}
+		if ctx.Err() != nil {
+			log.Printf("App not ready after --ready-timeout of %s", w.readyTimeout)
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(waitPollInterval):
+		}
+	}
+}
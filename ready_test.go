@@ -0,0 +1,18 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWaitReadyHangingCmd(t *testing.T) {
+	w := NewWatcher([]string{t.TempDir()}, time.Second, "", "", "", "", nil, nil)
+	w.readyCmd = "sleep 30; true"
+	w.readyTimeout = 200 * time.Millisecond
+
+	start := time.Now()
+	w.waitReady()
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("waitReady took %s with a --ready-timeout of 200ms", d)
+	}
+}
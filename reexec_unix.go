@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// reexec replaces the process with path, keeping its PID, so a daemon's
+// pidfile and the app's parent stay valid. It only returns on failure.
+func reexec(path string, args, env []string) error {
+	return syscall.Exec(path, args, env)
+}
@@ -0,0 +1,8 @@
+package main
+
+import "errors"
+
+// reexec is unavailable: Windows can't replace a running process's image.
+func reexec(path string, args, env []string) error {
+	return errors.New("re-exec is not supported on Windows")
+}
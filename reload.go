@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+	"syscall"
+)
+
+// reloadable reports whether every file in c matches a --reload-on rule, so
+// the running app can pick the change up on --reload-signal instead of
+// being restarted.
+func (w *Watcher) reloadable(c changeSet) bool {
+	if w.reloadSignal == 0 || len(w.reloadOn) == 0 || c.count() == 0 {
+		return false
+	}
+	for _, list := range [][]string{c.added, c.modified, c.deleted} {
+		for _, name := range list {
+			rel := w.relativeToRoot(name)
+			matched := false
+			for _, rule := range w.reloadOn {
+				if matchRule(rule, rel) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// reloadApp sends sig to every app process group and reports whether there
+// was an app to send it to.
+func (w *Watcher) reloadApp(sig syscall.Signal) bool {
+	w.processMu.Lock()
+	defer w.processMu.Unlock()
+	if len(w.processes) == 0 {
+		return false
+	}
+	for _, p := range w.processes {
+		p.signal(sig)
+	}
+	log.Printf("Sent SIG%s to the app instead of restarting it (--reload-on)", signalName(sig))
+	return true
+}
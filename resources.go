@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const resourceInterval = 5 * time.Second
+
+// resourceUsage is a point-in-time sample of the app's resource consumption.
+type resourceUsage struct {
+	RSSBytes   uint64  `json:"rss_bytes"`
+	CPUPercent float64 `json:"cpu_percent"`
+}
+
+type resourceTracker struct {
+	mu    sync.Mutex
+	usage *resourceUsage
+}
+
+func (t *resourceTracker) set(u *resourceUsage) {
+	t.mu.Lock()
+	t.usage = u
+	t.mu.Unlock()
+}
+
+func (t *resourceTracker) get() *resourceUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.usage
+}
+
+// appPID returns the PID of the first running app process, or 0.
+func (w *Watcher) appPID() int {
+	w.processMu.Lock()
+	defer w.processMu.Unlock()
+	if len(w.processes) == 0 {
+		return 0
+	}
+	return w.processes[0].cmd.Process.Pid
+}
+
+// resourceLoop samples the running app's RSS and CPU usage. A process that
+// exits between samples simply clears the last reading.
+func (w *Watcher) resourceLoop() {
+	var prevPID int
+	var prevCPU time.Duration
+	var prevAt time.Time
+
+	for w.sleep(resourceInterval) {
+		pid := w.appPID()
+		if pid == 0 {
+			w.resources.set(nil)
+			prevPID = 0
+			continue
+		}
+
+		rss, cpu, err := sampleProcess(pid)
+		if err != nil {
+			w.debugf("Resource sample for pid %d failed: %v", pid, err)
+			w.resources.set(nil)
+			prevPID = 0
+			continue
+		}
+
+		now := time.Now()
+		u := &resourceUsage{RSSBytes: rss}
+		if pid == prevPID {
+			u.CPUPercent = 100 * float64(cpu-prevCPU) / float64(now.Sub(prevAt))
+		}
+		prevPID, prevCPU, prevAt = pid, cpu, now
+
+		w.resources.set(u)
+		w.debugf("App resources: rss=%.1fMiB cpu=%.1f%%", float64(u.RSSBytes)/(1<<20), u.CPUPercent)
+	}
+}
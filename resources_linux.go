@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ, which is 100 on every mainstream Linux platform.
+const clockTicks = 100
+
+const resourceTrackingSupported = true
+
+// sampleProcess reads the resident set size and total CPU time of pid from
+// /proc.
+func sampleProcess(pid int) (uint64, time.Duration, error) {
+	statm, err := os.ReadFile(fmt.Sprintf("/proc/%d/statm", pid))
+	if err != nil {
+		return 0, 0, err
+	}
+	fields := strings.Fields(string(statm))
+	if len(fields) < 2 {
+		return 0, 0, fmt.Errorf("unexpected statm format")
+	}
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, 0, err
+	}
+	// The command name may contain spaces, so skip past its closing paren.
+	s := string(stat)
+	idx := strings.LastIndexByte(s, ')')
+	if idx < 0 {
+		return 0, 0, fmt.Errorf("unexpected stat format")
+	}
+	fields = strings.Fields(s[idx+1:])
+	if len(fields) < 13 {
+		return 0, 0, fmt.Errorf("unexpected stat format")
+	}
+	// utime and stime are fields 14 and 15 of the full line.
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	rss := pages * uint64(os.Getpagesize())
+	cpu := time.Duration(utime+stime) * time.Second / clockTicks
+	return rss, cpu, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+const resourceTrackingSupported = false
+
+func sampleProcess(pid int) (uint64, time.Duration, error) {
+	return 0, 0, errors.New("resource tracking is only supported on Linux")
+}
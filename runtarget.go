@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// runTarget returns the binary the run command launches when it is a plain
+// file path such as ./myapp or bin/server. Commands that start with a program
+// looked up on PATH, or with shell syntax, have no target to check.
+func runTarget(command string) string {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return ""
+	}
+	target := fields[0]
+	if !strings.Contains(target, "/") || strings.ContainsAny(target, "$`'\"*?;&|<>(){}") {
+		return ""
+	}
+	return target
+}
+
+// runTargetOf is the run target of process name's run command as it is
+// run, after --template expansion. A command whose template fails has no
+// target to check; starting it reports the error.
+func (w *Watcher) runTargetOf(name, runCmd string) string {
+	command, err := w.expand(runCmd, name, "", "")
+	if err != nil {
+		return ""
+	}
+	return runTarget(command)
+}
+
+// checkRunTarget verifies that a path-style run target exists and is
+// executable after a successful build.
+func (w *Watcher) checkRunTarget() error {
+	if !w.checkTarget {
+		return nil
+	}
+	w.processMu.Lock()
+	runCmds := slices.Clone(w.runCmds)
+	w.processMu.Unlock()
+	for i, runCmd := range runCmds {
+		if err := verifyRunTarget(w.runTargetOf(w.appName(i), runCmd)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func verifyRunTarget(target string) error {
+	if target == "" {
+		return nil
+	}
+
+	info, err := os.Stat(target)
+	if os.IsNotExist(err) {
+		if dir := filepath.Dir(target); !dirExists(dir) {
+			return fmt.Errorf("build succeeded but run target %s not found: its directory %s doesn't exist (create it, or use --ensure-dirs)", target, dir)
+		}
+		return fmt.Errorf("build succeeded but run target %s not found; check the build's output path", target)
+	}
+	if err != nil {
+		return fmt.Errorf("build succeeded but run target %s is not accessible: %v", target, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("build succeeded but run target %s is a directory", target)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o111 == 0 {
+		return fmt.Errorf("build succeeded but run target %s is not executable", target)
+	}
+	return nil
+}
+
+// buildOutputFlag finds the output path in build commands such as
+// "go build -o dist/app ." or "tsc --outDir=dist".
+var buildOutputFlag = regexp.MustCompile(`(?:^|\s)(?:-o|--output|--outDir|--out-dir)(?:=|\s+)([^\s;&|'"$]+)`)
+
+// outputDirs returns the directories the build is expected to write into:
+// those of the path-style run targets and of the build command's -o or
+// --output path. An --outDir style flag names the directory itself.
+func (w *Watcher) outputDirs() []string {
+	var dirs []string
+	add := func(dir string) {
+		if dir != "." && dir != "" && !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	w.processMu.Lock()
+	runCmds := slices.Clone(w.runCmds)
+	w.processMu.Unlock()
+	for i, runCmd := range runCmds {
+		if target := w.runTargetOf(w.appName(i), runCmd); target != "" {
+			add(filepath.Dir(target))
+		}
+	}
+	buildCmd, err := w.expand(w.buildCmd, "build", "", "")
+	if err != nil {
+		buildCmd = w.buildCmd
+	}
+	for _, m := range buildOutputFlag.FindAllStringSubmatch(buildCmd, -1) {
+		if strings.Contains(strings.ToLower(m[0]), "dir") {
+			add(filepath.Clean(m[1]))
+		} else {
+			add(filepath.Dir(m[1]))
+		}
+	}
+	return dirs
+}
+
+func dirExists(dir string) bool {
+	info, err := os.Stat(dir)
+	return err == nil && info.IsDir()
+}
+
+// ensureOutputDirs creates the build's missing output directories, for
+// --ensure-dirs.
+func (w *Watcher) ensureOutputDirs() error {
+	for _, dir := range w.outputDirs() {
+		if dirExists(dir) {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("--ensure-dirs: %w", err)
+		}
+		log.Printf("Created %s for the build output (--ensure-dirs)", dir)
+	}
+	return nil
+}
+
+// logMissingDirHint follows a failed build with a hint when one of its
+// output directories doesn't exist, the usual cause of a first build
+// failing with a bare "no such file or directory".
+func (w *Watcher) logMissingDirHint() {
+	for _, dir := range w.outputDirs() {
+		if !dirExists(dir) {
+			logHint(fmt.Sprintf("the build output directory %s doesn't exist; create it, or use --ensure-dirs", dir))
+			return
+		}
+	}
+}
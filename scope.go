@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Scopes route changes to the actions they concern, so one watcher can serve
+// a polyglot tree without a Go change rebuilding the frontend or a TypeScript
+// change restarting the API. An action is the build ("build": --check and
+// --build together), a --build-step by name, or a run process by its app
+// name ("app", or "app1", "app2"... with several --run). Precedence:
+//
+//   - --include and --exclude come first: a file they leave out is never
+//     seen, whatever the scopes say
+//   - an action without a --scope concerns every change
+//   - a scoped action concerns a changed file that matches none of its "!"
+//     rules and one of its other rules, or any file if it only has "!" rules
+//   - with no changed files to go by (the first build, or a forced rebuild
+//     with nothing changed) every action runs
+//
+// For example, a Go API with a TypeScript frontend served by a Node process:
+//
+//	--build 'go build -o api ./cmd/api' --scope 'build=.go,go.mod'
+//	--build-step 'web=npm run build' --scope 'web=web/,!web/dist/'
+//	--run ./api --scope 'app1=.go,go.mod'
+//	--run 'node web/server.js' --scope 'app2=web/'
+
+// buildStep is an extra build command run after --build, for --build-step.
+type buildStep struct {
+	name    string
+	command string
+}
+
+func parseBuildStep(s string) (buildStep, error) {
+	name, command, ok := strings.Cut(s, "=")
+	name, command = strings.TrimSpace(name), strings.TrimSpace(command)
+	if !ok || name == "" || command == "" {
+		return buildStep{}, fmt.Errorf("invalid --build-step %q, want name=command", s)
+	}
+	return buildStep{name: name, command: command}, nil
+}
+
+// actionScope is the set of files an action concerns, as rules matched like
+// --include.
+type actionScope struct {
+	includes []string
+	excludes []string // the "!" rules
+}
+
+func parseScope(s string) (string, actionScope, error) {
+	action, rules, ok := strings.Cut(s, "=")
+	action = strings.TrimSpace(action)
+	var scope actionScope
+	for _, rule := range splitRules(rules) {
+		rule = strings.TrimSpace(rule)
+		if exclude, ok := strings.CutPrefix(rule, "!"); ok {
+			if exclude != "" {
+				scope.excludes = append(scope.excludes, exclude)
+			}
+		} else if rule != "" {
+			scope.includes = append(scope.includes, rule)
+		}
+	}
+	if !ok || action == "" || len(scope.includes)+len(scope.excludes) == 0 {
+		return "", actionScope{}, fmt.Errorf("invalid --scope %q, want action=rules", s)
+	}
+	return action, scope, nil
+}
+
+func (s actionScope) matches(relPath string) bool {
+	for _, rule := range s.excludes {
+		if matchRule(rule, relPath) {
+			return false
+		}
+	}
+	if len(s.includes) == 0 {
+		return true
+	}
+	for _, rule := range s.includes {
+		if matchRule(rule, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseScopes parses --build-step and --scope values for runs run commands,
+// checking that step names are free and every scope names an action.
+func parseScopes(steps, scopes []string, runs int) ([]buildStep, map[string]actionScope, []error) {
+	var errs []error
+	actions := []string{"build"}
+	if runs == 1 {
+		actions = append(actions, "app")
+	} else {
+		for i := range runs {
+			actions = append(actions, fmt.Sprintf("app%d", i+1))
+		}
+	}
+
+	var parsed []buildStep
+	for _, s := range steps {
+		step, err := parseBuildStep(s)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if step.name == "check" || slices.Contains(actions, step.name) {
+			errs = append(errs, fmt.Errorf("invalid --build-step %q: the name %s is already taken", s, step.name))
+			continue
+		}
+		parsed = append(parsed, step)
+		actions = append(actions, step.name)
+	}
+
+	byAction := make(map[string]actionScope)
+	for _, s := range scopes {
+		action, scope, err := parseScope(s)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !slices.Contains(actions, action) {
+			errs = append(errs, fmt.Errorf("invalid --scope %q: no action named %s, want one of %s", s, action, strings.Join(actions, ", ")))
+			continue
+		}
+		if _, dup := byAction[action]; dup {
+			errs = append(errs, fmt.Errorf("--scope given twice for %s", action))
+			continue
+		}
+		byAction[action] = scope
+	}
+	return parsed, byAction, errs
+}
+
+// inScope reports whether action concerns any of the changed files.
+func (w *Watcher) inScope(action string, changed []string) bool {
+	scope, ok := w.scopes[action]
+	if !ok || len(changed) == 0 {
+		return true
+	}
+	for _, name := range changed {
+		if scope.matches(w.relativeToRoot(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// runBuildStep runs one --build-step, judged like the build command.
+func (w *Watcher) runBuildStep(step buildStep) error {
+	command, err := w.expand(wrapCommand(w.buildWrapper, step.command), step.name, "", "")
+	if err != nil {
+		return err
+	}
+	return w.runJudged(w.shellCmd("", command, nil))
+}
+
+// runScopedSteps runs the check and build commands and then every
+// --build-step, skipping those the changes don't concern. depsDone is the
+// --async-deps barrier. As with a build matrix, the app is left running
+// only if every command that ran asked for that.
+func (w *Watcher) runScopedSteps(depsDone func() error) error {
+	ran, noRestart := false, true
+	if w.inScope("build", w.buildChanged) {
+		err := w.runMainBuild(depsDone)
+		if err != nil && !errors.Is(err, errNoRestart) {
+			return err
+		}
+		ran, noRestart = true, err != nil
+	} else {
+		log.Println("No changes in the build's --scope, skipping it")
+	}
+	for _, step := range w.steps {
+		if !w.inScope(step.name, w.buildChanged) {
+			w.debugf("No changes in the --scope of build step %s, skipping it", step.name)
+			continue
+		}
+		if w.asyncDeps == "build" {
+			if err := depsDone(); err != nil {
+				return err
+			}
+		}
+		log.Printf("Running build step %s...", step.name)
+		err := w.runBuildStep(step)
+		if err != nil && !errors.Is(err, errNoRestart) {
+			return inStage(step.name, err)
+		}
+		ran, noRestart = true, noRestart && err != nil
+	}
+	if ran && noRestart {
+		return errNoRestart
+	}
+	return nil
+}
+
+// startAppInScope starts the app after a build, restarting only the
+// processes whose --scope the changes concern and starting any that aren't
+// running. It reports whether it started anything.
+func (w *Watcher) startAppInScope() (bool, error) {
+	w.processMu.Lock()
+	running := make(map[string]*appProcess)
+	for _, p := range w.processes {
+		running[p.name] = p
+	}
+	var stop []*appProcess
+	var start []int
+	for i := range w.runCmds {
+		name := w.appName(i)
+		p, ok := running[name]
+		if !ok {
+			start = append(start, i)
+		} else if w.inScope(name, w.buildChanged) {
+			stop = append(stop, p)
+			start = append(start, i)
+		}
+	}
+	if len(start) == len(w.runCmds) {
+		w.processMu.Unlock()
+		return true, w.startApp()
+	}
+	defer w.processMu.Unlock()
+	if len(start) == 0 {
+		log.Println("No changes in any app's --scope, leaving the app running")
+		return false, nil
+	}
+
+	if len(stop) > 0 {
+		log.Printf("Stopping %s...", strings.Join(procNames(stop), ", "))
+		if err := w.stopProcessesLocked(stop); err != nil {
+			return false, err
+		}
+		w.processes = slices.DeleteFunc(w.processes, func(p *appProcess) bool { return slices.Contains(stop, p) })
+	}
+	w.section("run")
+	for _, i := range start {
+		log.Printf("Starting %s...", w.appName(i))
+		if err := w.startProcessLocked(w.appName(i), w.runCmds[i], i == 0); err != nil {
+			return true, fmt.Errorf("starting %s: %w", w.appName(i), err)
+		}
+	}
+	w.appStartedAt.Store(time.Now().UnixNano())
+	return true, nil
+}
+
+func procNames(procs []*appProcess) []string {
+	names := make([]string, len(procs))
+	for i, p := range procs {
+		names[i] = p.name
+	}
+	return names
+}
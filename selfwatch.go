@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// selfExecEnv carries the Unix time of the last --watch-self re-exec into
+// the new process, for the restart-loop guard.
+const selfExecEnv = "POLY_WATCHER_SELF_EXEC"
+
+// selfRestartGap is the least time between two --watch-self re-execs; a
+// change sooner than that waits, so a binary or config that changes on
+// every start can't make the watcher restart in a tight loop.
+const selfRestartGap = 10 * time.Second
+
+// selfCheckInterval is how often the config file and binary are checked.
+const selfCheckInterval = time.Second
+
+// lastSelfExec returns when this process was started by a --watch-self
+// re-exec, or the zero time.
+func lastSelfExec() time.Time {
+	sec, err := strconv.ParseInt(os.Getenv(selfExecEnv), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+// selfStamps returns the size and mtime of every --watch-self path; a
+// missing file (as while a binary is being replaced) is the zero state.
+func selfStamps(paths []string) []fileState {
+	stamps := make([]fileState, len(paths))
+	for i, p := range paths {
+		if info, err := os.Stat(p); err == nil {
+			stamps[i] = fileState{size: info.Size(), modTime: info.ModTime()}
+		}
+	}
+	return stamps
+}
+
+func sameStamps(a, b []fileState) bool {
+	for i := range a {
+		if !a[i].equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// startSelfWatch adds the binary to the --watch-self paths and starts
+// watching them, unless the platform or the binary's path rules out a
+// re-exec.
+func (w *Watcher) startSelfWatch() {
+	if runtime.GOOS == "windows" {
+		log.Println("WARNING: --watch-self needs re-exec, which Windows doesn't support; not watching the config or binary")
+		return
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		log.Printf("WARNING: --watch-self can't find the poly-watcher binary (%v); not watching the config or binary", err)
+		return
+	}
+	w.selfExe = exe
+	w.selfPaths = append(w.selfPaths, exe)
+	w.selfExecAt = lastSelfExec()
+	go w.selfWatchLoop()
+}
+
+// selfWatchLoop queues a self-restart once the config file or the binary
+// changed and then stayed unchanged for a check, so a binary still being
+// written isn't executed.
+func (w *Watcher) selfWatchLoop() {
+	last := selfStamps(w.selfPaths)
+	pending := false
+	for w.sleep(selfCheckInterval) {
+		cur := selfStamps(w.selfPaths)
+		if !sameStamps(cur, last) {
+			last, pending = cur, true
+			continue
+		}
+		if pending && time.Since(w.selfExecAt) >= selfRestartGap {
+			pending = false
+			w.enqueue(triggerSelfRestart)
+		}
+	}
+}
+
+// selfRestart re-executes the watcher with its original arguments, after
+// checking that the new binary accepts the new config. The app is stopped
+// first: the new process can't take over the output pipes of one this
+// process started, so it builds and starts the app again (with
+// --state-file, an unchanged tree starts it without a rebuild).
+func (w *Watcher) selfRestart() {
+	log.Println("Config file or binary changed (--watch-self), checking the new one...")
+	out, err := exec.Command(w.selfExe, append(os.Args[1:], "--check-config")...).CombinedOutput()
+	if err != nil {
+		log.Printf("Not restarting: the new watcher rejects its settings (%v):\n%s", err, strings.TrimSpace(lastLines(string(out), 10)))
+		return
+	}
+
+	log.Println("Re-executing poly-watcher...")
+	if err := w.stopApp(); err != nil {
+		log.Println(err)
+	}
+	if w.mux != nil && w.mux.status != nil {
+		w.mux.closeStatus()
+	}
+	env := append(os.Environ(), fmt.Sprintf("%s=%d", selfExecEnv, time.Now().Unix()))
+	err = reexec(w.selfExe, os.Args, env)
+	// Only returns on failure
+	log.Printf("Re-exec failed: %v; carrying on with this watcher", err)
+	w.restart()
+}
+
+// lastLines returns the last n lines of s.
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
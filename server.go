@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// appStatus is the body of GET /status.
+type appStatus struct {
+	Running   bool           `json:"running"`
+	Paused    bool           `json:"paused"`
+	Building  bool           `json:"building"`
+	BuildTime string         `json:"build_elapsed,omitempty"` // e.g. "45s"
+	PID       int            `json:"pid,omitempty"`
+	Resources *resourceUsage `json:"resources,omitempty"`
+	LastCrash *crashReport   `json:"last_crash,omitempty"`
+	Errors    int            `json:"build_errors"` // in the output of the running or last build
+	Warnings  int            `json:"build_warnings"`
+}
+
+func (w *Watcher) status() appStatus {
+	pid := w.appPID()
+	st := appStatus{
+		Running:   pid != 0,
+		Paused:    w.paused.Load(),
+		PID:       pid,
+		Resources: w.resources.get(),
+		LastCrash: w.lastCrashReport(),
+	}
+	st.Errors, st.Warnings = w.diags.counts()
+	if elapsed := w.buildElapsed(); elapsed > 0 {
+		st.Building = true
+		st.BuildTime = elapsed.Round(time.Second).String()
+	}
+	return st
+}
+
+func writeJSON(rw http.ResponseWriter, v any) {
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		log.Println("Error writing response:", err)
+	}
+}
+
+// control guards endpoints that change what the watcher does. With
+// --http-auth-token they require "Authorization: Bearer <token>"; without
+// one they only accept requests from the local machine.
+func (w *Watcher) control(h http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if w.httpToken != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(w.httpToken)) != 1 {
+				rw.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(rw, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		} else if !isLoopback(r.RemoteAddr) {
+			http.Error(rw, "control endpoints are local-only without --http-auth-token", http.StatusForbidden)
+			return
+		}
+		h(rw, r)
+	}
+}
+
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// sseBuffer is how many messages a slow SSE client may fall behind before
+// messages are dropped for it.
+const sseBuffer = 256
+
+// streamSSE writes each value of backlog and then each from ch as a JSON
+// server-sent event, skipping those keep rejects, until the client
+// disconnects or the watcher stops. A nil ch ends the stream after the
+// backlog; a nil keep keeps everything.
+func streamSSE[T any](w *Watcher, rw http.ResponseWriter, r *http.Request, backlog []T, ch <-chan T, keep func(T) bool) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+
+	send := func(v T) bool {
+		if keep != nil && !keep(v) {
+			return true
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			log.Println("Error encoding event:", err)
+			return true
+		}
+		if _, err := fmt.Fprintf(rw, "data: %s\n\n", data); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+	for _, v := range backlog {
+		if !send(v) {
+			return
+		}
+	}
+	if ch == nil {
+		return
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-w.stopCh:
+			return
+		case v := <-ch:
+			if !send(v) {
+				return
+			}
+		}
+	}
+}
+
+// serveHTTP runs the status server on addr until it fails or the watcher
+// stops.
+func (w *Watcher) serveHTTP(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(rw http.ResponseWriter, r *http.Request) {
+		writeJSON(rw, w.status())
+	})
+	mux.HandleFunc("GET /history", func(rw http.ResponseWriter, r *http.Request) {
+		writeJSON(rw, w.history.list())
+	})
+	mux.HandleFunc("GET /events", func(rw http.ResponseWriter, r *http.Request) {
+		ch := w.eventHub.subscribe(sseBuffer)
+		defer w.eventHub.unsubscribe(ch)
+		streamSSE(w, rw, r, nil, ch, nil)
+	})
+	// GET /logs/stream takes since, level and source to filter (see
+	// logFilter) and follow=0 to stop after the remembered lines. Without
+	// since it only streams new output.
+	mux.HandleFunc("GET /logs/stream", func(rw http.ResponseWriter, r *http.Request) {
+		filter, err := parseLogFilter(r.URL.Query())
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !r.URL.Query().Has("since") && r.URL.Query().Get("follow") != "0" {
+			filter.since = time.Now()
+		}
+		backlog, ch := w.output.subscribeSince(sseBuffer, filter.since)
+		defer w.output.unsubscribe(ch)
+		if r.URL.Query().Get("follow") == "0" {
+			streamSSE(w, rw, r, backlog, nil, filter.keep)
+			return
+		}
+		streamSSE(w, rw, r, backlog, ch, filter.keep)
+	})
+	mux.HandleFunc("POST /rebuild", w.control(func(rw http.ResponseWriter, r *http.Request) {
+		w.enqueue(triggerRebuild)
+		rw.WriteHeader(http.StatusAccepted)
+	}))
+	mux.HandleFunc("POST /restart", w.control(func(rw http.ResponseWriter, r *http.Request) {
+		w.enqueue(triggerRestart)
+		rw.WriteHeader(http.StatusAccepted)
+	}))
+	mux.HandleFunc("POST /pause", w.control(func(rw http.ResponseWriter, r *http.Request) {
+		w.enqueue(triggerPause)
+		rw.WriteHeader(http.StatusAccepted)
+	}))
+	mux.HandleFunc("POST /resume", w.control(func(rw http.ResponseWriter, r *http.Request) {
+		w.enqueue(triggerResume)
+		rw.WriteHeader(http.StatusAccepted)
+	}))
+	mux.HandleFunc("GET /explain", w.handleExplain)
+	// POST /checkpoint/{name} snapshots the latest scan of the tree; GET
+	// /diff/{name} lists what changed since.
+	mux.HandleFunc("POST /checkpoint/{name}", w.control(w.handleCheckpoint))
+	mux.HandleFunc("GET /diff/{name}", w.handleDiff)
+	// PUT /run replaces the run command with the request body and restarts
+	// the app with it, without rebuilding.
+	mux.HandleFunc("PUT /run", w.control(func(rw http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		command := strings.TrimSpace(string(body))
+		if command == "" {
+			http.Error(rw, "run command must not be empty", http.StatusBadRequest)
+			return
+		}
+		w.SetRunCommand(command)
+		rw.WriteHeader(http.StatusAccepted)
+	}))
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-w.stopCh
+		_ = srv.Close()
+	}()
+
+	var err error
+	if w.tlsCert != "" {
+		log.Printf("Status server listening on https://%s", addr)
+		err = srv.ListenAndServeTLS(w.tlsCert, w.tlsKey)
+	} else {
+		log.Printf("Status server listening on http://%s", addr)
+		err = srv.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Println("Status server stopped:", err)
+	}
+}
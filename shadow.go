@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// shadowPrefix names the temporary copies of the tree that shadow builds run
+// in. They live inside the root so outputs can be renamed into place without
+// crossing filesystems; the scan skips them like any other hidden directory.
+const shadowPrefix = ".poly-watcher-shadow-"
+
+// runShadowBuild runs command from a hard-linked copy of the root and, if it
+// succeeds, renames each configured output into the real tree, so the app
+// never sees half-written artifacts. Outputs are left out of the copy so the
+// build creates them fresh instead of writing through a link to the original,
+// and so is whatever the scan skips.
+func (w *Watcher) runShadowBuild(command string, env []string) error {
+	root := w.roots[0]
+	dir, err := os.MkdirTemp(root, shadowPrefix)
+	if err != nil {
+		return fmt.Errorf("creating shadow tree: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	outs, err := w.expandedShadowOutputs()
+	if err != nil {
+		return err
+	}
+	if err := w.linkTree(root, dir, outs); err != nil {
+		return fmt.Errorf("creating shadow tree: %w", err)
+	}
+	err = w.runBuildCommand(dir, command, env)
+	if err != nil && !errors.Is(err, errNoRestart) {
+		return err
+	}
+	for _, out := range outs {
+		if err := swapOutput(filepath.Join(dir, out), filepath.Join(root, out)); err != nil {
+			return fmt.Errorf("moving %s into place: %w", out, err)
+		}
+	}
+	return err
+}
+
+// shadowOutput reports whether rel (slash-separated, relative to the root) is
+// or lives under one of outs.
+func shadowOutput(outs []string, rel string) bool {
+	for _, out := range outs {
+		out = filepath.ToSlash(out)
+		if rel == out || strings.HasPrefix(rel, out+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// linkTree mirrors src into dst, hard-linking regular files and falling back
+// to a copy where links aren't possible. The outputs outs are left out, as
+// are hidden directories (.git, other shadow trees) and excluded paths
+// (node_modules), like the scan leaves them out.
+func (w *Watcher) linkTree(src, dst string, outs []string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		hidden := d.IsDir() && strings.HasPrefix(d.Name(), ".")
+		if hidden || w.excluded(rel) || shadowOutput(outs, filepath.ToSlash(rel)) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case d.IsDir():
+			return os.Mkdir(target, 0o755)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			if os.Link(p, target) == nil {
+				return nil
+			}
+			return copyFile(p, target)
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// swapOutput renames the built output src over dst. Files are replaced
+// atomically; a directory is swapped with two renames, so there is a brief
+// moment where dst is missing.
+func swapOutput(src, dst string) error {
+	if _, err := os.Lstat(src); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	info, err := os.Lstat(dst)
+	if err != nil || !info.IsDir() {
+		return os.Rename(src, dst)
+	}
+	old := filepath.Join(filepath.Dir(dst), shadowPrefix+"old-"+filepath.Base(dst))
+	os.RemoveAll(old)
+	if err := os.Rename(dst, old); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		if rerr := os.Rename(old, dst); rerr != nil {
+			log.Printf("Error restoring %s: %v", dst, rerr)
+		}
+		return err
+	}
+	return os.RemoveAll(old)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLinkTreeSkips(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	for _, name := range []string{"main.go", ".env", "web/app.js", ".git/HEAD", "web/node_modules/x.js", "bin/app"} {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := NewWatcher([]string{src}, time.Second, "", "", "", "", nil, []string{"node_modules"})
+
+	if err := w.linkTree(src, dst, []string{"bin"}); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{
+		"main.go":          true,
+		".env":             true,
+		"web/app.js":       true,
+		".git":             false,
+		"web/node_modules": false,
+		"bin":              false,
+	} {
+		_, err := os.Lstat(filepath.Join(dst, name))
+		if got := err == nil; got != want {
+			t.Errorf("%s in the shadow tree: %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleSignals maps SIGUSR1 to a forced rebuild+restart and SIGUSR2 to a
+// restart-only, e.g. `kill -USR1 <pid>` from an editor save hook.
+func (w *Watcher) handleSignals() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		defer signal.Stop(sigs)
+		for {
+			select {
+			case <-w.stopCh:
+				return
+			case sig := <-sigs:
+				switch sig {
+				case syscall.SIGUSR1:
+					w.enqueue(triggerRebuild)
+				case syscall.SIGUSR2:
+					w.enqueue(triggerRestart)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,4 @@
+package main
+
+// handleSignals is a no-op on Windows, which has no SIGUSR1/SIGUSR2.
+func (w *Watcher) handleSignals() {}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"log"
+)
+
+// stageError attributes a failed build cycle to the pipeline stage that
+// failed: the dep commands ("deps"), --check ("check") or the build itself
+// ("build"). Every stage is required; one failing ends the cycle and the
+// app from the last good build keeps running.
+type stageError struct {
+	stage string
+	err   error
+}
+
+func (e *stageError) Error() string { return e.err.Error() }
+func (e *stageError) Unwrap() error { return e.err }
+
+// inStage wraps a failure of stage. A build that only asked not to restart
+// the app (errNoRestart) is not a failure and is returned as is.
+func inStage(stage string, err error) error {
+	if err == nil || errors.Is(err, errNoRestart) {
+		return err
+	}
+	return &stageError{stage: stage, err: err}
+}
+
+// failedStage names the stage err is attributed to, or "build" when it
+// isn't attributed.
+func failedStage(err error) string {
+	var se *stageError
+	if errors.As(err, &se) {
+		return se.stage
+	}
+	return "build"
+}
+
+// logNotRestarted reports that a failed cycle left the previous app running.
+func (w *Watcher) logNotRestarted(err error) {
+	if w.appRunning() {
+		log.Printf("App not restarted: stage '%s' failed; still running the last good build", failedStage(err))
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunBuildFailedStage(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		fail      []string // the commands that exit 1
+		asyncDeps string
+		stage     string
+		ran       []string
+	}{
+		{"deps", []string{"deps"}, "", "deps", []string{"deps"}},
+		{"check", []string{"check"}, "", "check", []string{"deps", "check"}},
+		{"build", []string{"build"}, "", "build", []string{"deps", "check", "build"}},
+		// Background deps overlap with the check, or with the build too, and
+		// their failure is likely why a later step failed as well
+		{"async deps", []string{"deps"}, "build", "deps", []string{"deps", "check"}},
+		{"async deps past the build", []string{"deps"}, "run", "deps", []string{"deps", "check", "build"}},
+		{"async deps and build", []string{"deps", "build"}, "run", "deps", []string{"deps", "check", "build"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cmd := func(stage string) string {
+				c := fmt.Sprintf("touch %s", filepath.Join(dir, stage))
+				if slices.Contains(tt.fail, stage) {
+					c += " && exit 1"
+				}
+				return c
+			}
+			w := NewWatcher([]string{dir}, time.Second, cmd("build"), "", "go.mod", cmd("deps"), nil, nil)
+			w.checkCmd = cmd("check")
+			w.asyncDeps = tt.asyncDeps
+			// go.mod changed since its command last ran
+			w.deps = depTracker{rules: map[string]int{"go.mod": 0}, current: map[string]string{"go.mod": "v2"}}
+
+			err := w.runBuild()
+			if err == nil {
+				t.Fatal("runBuild succeeded")
+			}
+			if got := failedStage(err); got != tt.stage {
+				t.Errorf("failedStage(%v) = %q, want %q", err, got, tt.stage)
+			}
+			for _, stage := range []string{"deps", "check", "build"} {
+				_, statErr := os.Stat(filepath.Join(dir, stage))
+				if ran := statErr == nil; ran != slices.Contains(tt.ran, stage) {
+					t.Errorf("%s ran = %v", stage, ran)
+				}
+			}
+		})
+	}
+}
+
+func TestRebuildFailedStageKeepsApp(t *testing.T) {
+	for _, stage := range []string{"check", "build"} {
+		t.Run(stage, func(t *testing.T) {
+			w := NewWatcher([]string{t.TempDir()}, time.Second, "true", "sleep 60", "", "", nil, nil)
+			w.checkCmd = "true"
+			if err := w.startApp(); err != nil {
+				t.Fatal(err)
+			}
+			defer w.stopApp()
+			pid := w.processes[0].cmd.Process.Pid
+
+			if stage == "check" {
+				w.checkCmd = "exit 1"
+			} else {
+				w.buildCmd = "exit 1"
+			}
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+			w.rebuild("change", changeSet{modified: []string{"main.go"}})
+
+			w.processMu.Lock()
+			procs := slices.Clone(w.processes)
+			w.processMu.Unlock()
+			if len(procs) != 1 || procs[0].cmd.Process.Pid != pid {
+				t.Errorf("after a failed %s: %d app processes, want pid %d left running", stage, len(procs), pid)
+			}
+			if want := fmt.Sprintf("App not restarted: stage '%s' failed", stage); !strings.Contains(logs.String(), want) {
+				t.Errorf("log %q doesn't say %q", logs.String(), want)
+			}
+		})
+	}
+}
+
+// Run with -race: the run loop keeps using the dep tracker while background
+// dep commands run, so only the barrier may record their result.
+func TestRunDepsAsyncCommitsOnBarrier(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWatcher([]string{dir}, time.Second, "true", "", "go.mod", "sleep 0.1", nil, nil)
+	w.asyncDeps = "run"
+	w.stateFile = filepath.Join(dir, "state.json")
+	w.deps = depTracker{rules: map[string]int{"go.mod": 0}, current: map[string]string{"go.mod": "v2"}}
+
+	depsDone := w.runDepsAsync()
+	for range 5 {
+		if len(w.changedDeps()) != 1 || w.watchSet.stale {
+			t.Fatal("dep result recorded before the barrier")
+		}
+		w.saveState()
+		time.Sleep(30 * time.Millisecond)
+	}
+	if err := depsDone(); err != nil {
+		t.Fatal(err)
+	}
+	if changed := w.changedDeps(); len(changed) != 0 {
+		t.Errorf("changedDeps() after the barrier = %v, want none", changed)
+	}
+	if !w.watchSet.stale {
+		t.Error("watch set not marked stale after the dep command ran")
+	}
+	data, err := os.ReadFile(w.stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"v2"`) {
+		t.Errorf("state file %s doesn't record the dep hash", data)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stateTempPrefix names the temporary file the state is written to before
+// being renamed into place.
+const stateTempPrefix = ".poly-watcher-state-"
+
+// watcherState is what --state-file persists between watcher runs. Hash and
+// Files describe the tree as of the last successful build; the whole state
+// is discarded when Config no longer matches the current settings.
+type watcherState struct {
+	Config    string                    `json:"config"`
+	Hash      string                    `json:"hash,omitempty"`
+	Files     map[string]persistedState `json:"files,omitempty"`
+	DepHashes map[string]string         `json:"dep_hashes,omitempty"`
+
+	Checkpoints map[string]persistedCheckpoint `json:"checkpoints,omitempty"`
+}
+
+type persistedState struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Mode    uint32    `json:"mode,omitempty"`
+	Sum     string    `json:"sum,omitempty"`
+}
+
+// loadState restores the state file, reporting whether a previous build was
+// recovered.
+func (w *Watcher) loadState() bool {
+	if w.stateFile == "" {
+		return false
+	}
+	data, err := os.ReadFile(w.stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return false
+	}
+	if err != nil {
+		log.Printf("Error reading state file %s: %v", w.stateFile, err)
+		return false
+	}
+	var st watcherState
+	if err := json.Unmarshal(data, &st); err != nil {
+		log.Printf("Ignoring corrupt state file %s: %v", w.stateFile, err)
+		return false
+	}
+	if st.Config != w.configHash {
+		log.Printf("Settings changed since %s was written, ignoring it", w.stateFile)
+		return false
+	}
+	w.deps.committed = st.DepHashes
+	w.checkpoints.restore(st.Checkpoints)
+
+	hash, err := hex.DecodeString(st.Hash)
+	if err != nil || len(hash) == 0 || st.Files == nil {
+		return false
+	}
+	files := make(map[string]fileState, len(st.Files))
+	for path, f := range st.Files {
+		files[path] = fileState{size: f.Size, modTime: f.ModTime, mode: fs.FileMode(f.Mode), sum: f.Sum}
+	}
+	w.prevHash, w.builtHash = string(hash), string(hash)
+	w.prevFiles, w.builtFiles = files, files
+	return true
+}
+
+// saveState writes the state file atomically so a crash mid-write never
+// leaves it truncated.
+func (w *Watcher) saveState() {
+	if w.stateFile == "" {
+		return
+	}
+	st := watcherState{Config: w.configHash, DepHashes: w.deps.committed, Checkpoints: w.checkpoints.persisted()}
+	if w.builtHash != "" {
+		st.Hash = hex.EncodeToString([]byte(w.builtHash))
+		st.Files = make(map[string]persistedState, len(w.builtFiles))
+		for path, f := range w.builtFiles {
+			st.Files[path] = persistedState{Size: f.size, ModTime: f.modTime, Mode: uint32(f.mode), Sum: f.sum}
+		}
+	}
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		log.Println("Error encoding state:", err)
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(w.stateFile), stateTempPrefix+"*")
+	if err != nil {
+		log.Println("Error writing state file:", err)
+		return
+	}
+	_, werr := tmp.Write(data)
+	cerr := tmp.Close()
+	if werr != nil || cerr != nil {
+		os.Remove(tmp.Name())
+		log.Println("Error writing state file:", errors.Join(werr, cerr))
+		return
+	}
+	if err := os.Rename(tmp.Name(), w.stateFile); err != nil {
+		os.Remove(tmp.Name())
+		log.Println("Error writing state file:", err)
+	}
+}
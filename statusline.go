@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// statusLine is the line --status-line keeps at the bottom of the terminal.
+// The logMux clears it before writing output and draws it again after, so
+// logs scroll above it. Its fields are guarded by the mux lock.
+type statusLine struct {
+	out         *os.File
+	text        string
+	shown       bool // drawn and not yet cleared
+	atLineStart bool // the last output ended with a newline
+	closed      bool
+}
+
+func (s *statusLine) clear() {
+	if s.shown {
+		fmt.Fprint(s.out, "\r\x1b[2K")
+		s.shown = false
+	}
+}
+
+// draw writes the status, cut to the terminal's current width so it never
+// wraps, which also keeps it right after a resize. It waits while a line
+// without its newline (e.g. a prompt) is on screen.
+func (s *statusLine) draw() {
+	if s.closed || !s.atLineStart || s.text == "" {
+		return
+	}
+	text := s.text
+	if width := terminalWidth(s.out); width > 1 && utf8.RuneCountInString(text) >= width {
+		text = string([]rune(text)[:width-2]) + "…"
+	}
+	fmt.Fprint(s.out, "\r\x1b[2K"+text)
+	s.shown = true
+}
+
+// setStatus replaces the status line's text.
+func (m *logMux) setStatus(text string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.status.text = text
+	m.status.draw()
+}
+
+// closeStatus removes the status line for good, leaving the cursor at the
+// start of an empty line.
+func (m *logMux) closeStatus() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.status.clear()
+	m.status.closed = true
+}
+
+// statusText summarizes the watcher for the status line, e.g.
+// "✓ up · last build 1.2s · 342 files · watching".
+func (w *Watcher) statusText(lastBuild time.Duration, lastErr error, built bool) string {
+	var state string
+	switch {
+	case w.buildElapsed() > 0:
+		state = fmt.Sprintf("⟳ building %s", w.buildElapsed().Round(time.Second))
+	case lastErr != nil:
+		state = "✗ build failed"
+	case w.appRunning():
+		state = "✓ up"
+	case built:
+		state = "○ app not running"
+	default:
+		state = "… starting"
+	}
+	parts := []string{state}
+	if built {
+		parts = append(parts, "last build "+lastBuild.Round(100*time.Millisecond).String())
+	}
+	parts = append(parts, fmt.Sprintf("%d files", w.fileCount.Load()))
+	if w.paused.Load() {
+		parts = append(parts, "paused")
+	} else {
+		parts = append(parts, "watching")
+	}
+	return strings.Join(parts, " · ")
+}
+
+// statusLoop keeps the status line current: on every event from events,
+// and once a second for the build timer and terminal resizes.
+func (w *Watcher) statusLoop(events chan Event) {
+	defer w.eventHub.unsubscribe(events)
+	defer w.mux.closeStatus()
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	var (
+		buildStart time.Time
+		lastBuild  time.Duration
+		lastErr    error
+		built      bool
+	)
+	for {
+		w.mux.setStatus(w.statusText(lastBuild, lastErr, built))
+		select {
+		case <-w.stopCh:
+			return
+		case <-tick.C:
+		case ev := <-events:
+			switch ev.Type {
+			case BuildStarted:
+				buildStart = ev.Time
+			case BuildFinished:
+				lastBuild, lastErr, built = ev.Time.Sub(buildStart), ev.Err, true
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// templateBuiltins are the variables --template provides itself; --var may
+// not redefine them.
+var templateBuiltins = []string{"Name", "Workdir", "Root", "Changed", "Matrix", "Profile"}
+
+var templateVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// templateFuncs are available in command templates besides the text/template
+// builtins.
+var templateFuncs = template.FuncMap{
+	"join":    strings.Join,
+	"shquote": shellQuote,
+}
+
+// shellQuote quotes s as a single sh word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func parseTemplateVar(s string) (name, value string, err error) {
+	name, value, ok := strings.Cut(s, "=")
+	switch {
+	case !ok || !templateVarName.MatchString(name):
+		return "", "", fmt.Errorf("invalid --var %q, want Name=value with Name a letter or _ followed by letters, digits or _", s)
+	case slices.Contains(templateBuiltins, name):
+		return "", "", fmt.Errorf("--var %s: %s is a builtin template variable", s, name)
+	}
+	return name, value, nil
+}
+
+func parseCommandTemplate(name, command string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(command)
+}
+
+// expand renders command, or an output path, as a template with
+// --template; name is "build", "check" or the app's process name, dir the directory it runs in (empty
+// for the watcher's own) and matrix the matrix entry's label, if any.
+// Commands without "{{" are returned as they are.
+func (w *Watcher) expand(command, name, dir, matrix string) (string, error) {
+	if !w.templateCmds || !strings.Contains(command, "{{") {
+		return command, nil
+	}
+	t, err := parseCommandTemplate(name, command)
+	if err != nil {
+		return "", fmt.Errorf("%s command template: %w", name, err)
+	}
+	if dir == "" {
+		if dir, err = os.Getwd(); err != nil {
+			return "", err
+		}
+	}
+	data := map[string]any{
+		"Name":    name,
+		"Workdir": dir,
+		"Root":    w.roots[0],
+		"Changed": w.buildChanged,
+		"Matrix":  matrix,
+		"Profile": w.profile,
+	}
+	for k, v := range w.templateVars {
+		data[k] = v
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("%s command template: %w", name, err)
+	}
+	return b.String(), nil
+}
+
+// expandedShadowOutputs renders each --shadow-output as a template, as the
+// build command sees it, with --template.
+func (w *Watcher) expandedShadowOutputs() ([]string, error) {
+	outs := make([]string, 0, len(w.shadowOutputs))
+	for _, out := range w.shadowOutputs {
+		expanded, err := w.expand(out, "build", "", "")
+		if err != nil {
+			return nil, fmt.Errorf("--shadow-output: %w", err)
+		}
+		expanded = filepath.Clean(expanded)
+		if !filepath.IsLocal(expanded) {
+			return nil, fmt.Errorf("--shadow-output %q expands to %q, which isn't a path inside the root", out, expanded)
+		}
+		outs = append(outs, expanded)
+	}
+	return outs, nil
+}
+
+// changedSinceBuild lists the files changed since the last successful
+// build, for {{.Changed}}; it is empty for the first build.
+func (w *Watcher) changedSinceBuild() []string {
+	if w.builtFiles == nil {
+		return nil
+	}
+	c := diffFiles(w.builtFiles, w.prevFiles)
+	changed := slices.Concat(c.added, c.modified, c.deleted)
+	slices.Sort(changed)
+	return changed
+}
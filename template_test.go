@@ -0,0 +1,33 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTemplateOutputPaths(t *testing.T) {
+	w := NewWatcher([]string{t.TempDir()}, time.Second, "go build -o bin/{{.Svc}} .", "./bin/{{.Svc}} --port={{.Port}}", "", "", nil, nil)
+	w.templateCmds = true
+	w.templateVars = map[string]string{"Svc": "api", "Port": "8080"}
+	w.shadowOutputs = []string{"bin/{{.Svc}}", "static"}
+
+	if got := w.runTargetOf("app", w.runCmds[0]); got != "./bin/api" {
+		t.Errorf("run target %q, want ./bin/api", got)
+	}
+	if got := w.outputDirs(); !slices.Equal(got, []string{"bin"}) {
+		t.Errorf("output dirs %q, want [bin]", got)
+	}
+	outs, err := w.expandedShadowOutputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"bin/api", "static"}; !slices.Equal(outs, want) {
+		t.Errorf("shadow outputs %q, want %q", outs, want)
+	}
+
+	w.templateVars["Svc"] = "../../api"
+	if _, err := w.expandedShadowOutputs(); err == nil {
+		t.Error("a --shadow-output expanding outside the root was accepted")
+	}
+}
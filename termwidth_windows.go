@@ -0,0 +1,9 @@
+package main
+
+import "os"
+
+// terminalWidth isn't queried on Windows; the status line assumes the
+// classic console width.
+func terminalWidth(f *os.File) int {
+	return 80
+}
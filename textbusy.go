@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// A freshly built binary can't be executed while anything still has it open
+// for writing (ETXTBSY), which races with builds whose writer, or a child
+// that inherited its descriptor, lets go of it a moment late. Such a start
+// is retried up to textBusyRetries times, textBusyDelay apart.
+const (
+	textBusyRetries = 3
+	textBusyDelay   = 200 * time.Millisecond
+	textBusyWindow  = time.Second // how soon after starting the failure must come
+	startTailLines  = 5           // stderr lines kept to tell why a start failed
+)
+
+// textBusy reports whether proc exiting with err looks like ETXTBSY. The run
+// command goes through the shell, which reports a failed exec as exit status
+// 126 right away, along with "Text file busy" on stderr. Other exec failures,
+// e.g. a binary for another platform or one that isn't executable, exit 126
+// too, so only the message tells them apart.
+func (w *Watcher) textBusy(proc *appProcess, err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 126 || time.Since(proc.started) > textBusyWindow {
+		return false
+	}
+	for _, line := range proc.startTail.get() {
+		if strings.Contains(strings.ToLower(line), "text file busy") {
+			return true
+		}
+	}
+	return false
+}
+
+// retryTextBusy starts proc's run command again after it hit "text file
+// busy", unless the watcher stopped or something else started it meanwhile.
+func (w *Watcher) retryTextBusy(proc *appProcess) {
+	if !w.sleep(textBusyDelay) {
+		return
+	}
+	w.processMu.Lock()
+	defer w.processMu.Unlock()
+	for _, p := range w.processes {
+		if p.name == proc.name {
+			return
+		}
+	}
+	if err := w.launchLocked(proc.name, proc.command, proc.stdin, proc.busyRetries+1); err != nil {
+		log.Printf("%s restart failed: %v", appLabel(proc.name), err)
+	}
+}
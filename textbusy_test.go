@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestTextBusy(t *testing.T) {
+	// How the shell reports any exec that failed
+	err := exec.Command("/bin/sh", "-c", "exit 126").Run()
+	w := NewWatcher([]string{t.TempDir()}, time.Second, "", "", "", "", nil, nil)
+	for _, tt := range []struct {
+		stderr string
+		busy   bool
+	}{
+		{"/bin/sh: 1: ./app: Text file busy", true},
+		{"/bin/sh: 1: ./app: Exec format error", false},
+		{"/bin/sh: 1: ./app: Permission denied", false},
+	} {
+		proc := &appProcess{name: "app", command: "./app", started: time.Now(), startTail: &tailBuffer{max: startTailLines}}
+		proc.startTail.add(tt.stderr)
+		if got := w.textBusy(proc, err); got != tt.busy {
+			t.Errorf("textBusy after %q = %v, want %v", tt.stderr, got, tt.busy)
+		}
+	}
+}
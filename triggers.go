@@ -0,0 +1,125 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// trigger is an explicit request to act outside the normal change detection.
+type trigger int
+
+const (
+	triggerRebuild     trigger = iota // rebuild and restart the app
+	triggerRestart                    // restart the app only
+	triggerRunCmd                     // switch to a new run command, restart only
+	triggerPause                      // stop acting on changes
+	triggerResume                     // act on changes again
+	triggerQuietStart                 // a --quiet-schedule window began
+	triggerQuietEnd                   // a --quiet-schedule window ended
+	triggerSource                     // --change-source-cmd asked for a rebuild
+	triggerSelfRestart                // --watch-self saw the config or binary change
+)
+
+func (t trigger) String() string {
+	switch t {
+	case triggerRebuild:
+		return "rebuild"
+	case triggerRestart:
+		return "restart"
+	case triggerRunCmd:
+		return "run command update"
+	case triggerPause:
+		return "pause"
+	case triggerResume:
+		return "resume"
+	case triggerQuietStart:
+		return "quiet period start"
+	case triggerQuietEnd:
+		return "quiet period end"
+	case triggerSource:
+		return "change source"
+	case triggerSelfRestart:
+		return "self-restart"
+	}
+	return "unknown"
+}
+
+// enqueue queues t for the run loop. Triggers are explicit user intent and
+// are acted on as soon as the loop is idle. If the queue is full the trigger
+// is dropped, since an identical action is already pending.
+func (w *Watcher) enqueue(t trigger) {
+	select {
+	case w.triggers <- t:
+	default:
+		log.Printf("Ignoring %s request: one is already queued", t)
+	}
+}
+
+func (w *Watcher) handleTrigger(t trigger) {
+	w.lastActivity = time.Now()
+	switch t {
+	case triggerRebuild:
+		log.Println("Rebuild requested")
+		w.rebuild("manual", changeSet{})
+	case triggerRestart:
+		log.Println("Restart requested")
+		w.restart()
+	case triggerRunCmd:
+		w.processMu.Lock()
+		w.runCmds[0] = w.pendingRunCmd
+		w.processMu.Unlock()
+		log.Printf("Run command changed to %q, restarting without rebuild", w.pendingRunCmd)
+		w.restart()
+	case triggerPause:
+		if !w.paused.Swap(true) {
+			log.Println("PAUSED: changes will not trigger rebuilds until resumed")
+			w.changedWhilePaused = false
+		}
+	case triggerResume:
+		w.quietPaused = false
+		if w.paused.Swap(false) {
+			log.Println("Resumed watching")
+			if w.changedWhilePaused && w.rebuildOnResume {
+				log.Println("Files changed while paused, rebuilding...")
+				w.rebuild("resume", changeSet{})
+			}
+		}
+	case triggerQuietStart:
+		if spec, ok := w.quietPeriod(time.Now()); ok {
+			w.enterQuiet(spec)
+		}
+	case triggerQuietEnd:
+		w.leaveQuiet()
+	case triggerSource:
+		w.rebuildFromSource()
+	case triggerSelfRestart:
+		w.selfRestart()
+	}
+}
+
+// SetRunCommand replaces the (first) run command and restarts the app with it,
+// without rebuilding. The change is applied by the run loop, so it never
+// races with a build in progress.
+func (w *Watcher) SetRunCommand(command string) {
+	w.processMu.Lock()
+	w.pendingRunCmd = command
+	w.processMu.Unlock()
+	w.enqueue(triggerRunCmd)
+}
+
+// triggerFileTouched reports whether the trigger file was created or had its
+// mtime bumped since the last check. The first check only records a baseline.
+func (w *Watcher) triggerFileTouched() bool {
+	if w.triggerFile == "" {
+		return false
+	}
+	var mtime time.Time
+	if info, err := os.Stat(w.triggerFile); err == nil {
+		mtime = info.ModTime()
+	}
+	touched := w.triggerFileSeen && !mtime.IsZero() && !mtime.Equal(w.triggerFileMTime)
+	w.triggerFileMTime = mtime
+	w.triggerFileSeen = true
+	return touched
+}
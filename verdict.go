@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"sync"
+)
+
+// buildVerdict watches a build's output for --fail-on-stderr,
+// --fail-pattern and --success-pattern, for tools whose exit code can't be
+// trusted, and for files the build couldn't write. Patterns are matched
+// against each line of stdout and stderr.
+type buildVerdict struct {
+	w *Watcher
+
+	mu        sync.Mutex
+	wroteErr  bool
+	failLine  string
+	failed    bool
+	succeeded bool
+	writeLine string // first line reporting a file that couldn't be written
+}
+
+func (v *buildVerdict) line(stderr bool, line string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if stderr && line != "" {
+		v.wroteErr = true
+	}
+	v.w.diags.line(line)
+	if v.writeLine == "" && writeFailure.MatchString(line) {
+		v.writeLine = line
+	}
+	if v.w.failPattern != nil && !v.failed && v.w.failPattern.MatchString(line) {
+		v.failed, v.failLine = true, line
+	}
+	if v.w.successPattern != nil && v.w.successPattern.MatchString(line) {
+		v.succeeded = true
+	}
+}
+
+// judge turns the exit status err into the build's result.
+func (v *buildVerdict) judge(err error) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	err = v.decide(err)
+	if err != nil && v.writeLine != "" {
+		return &writeError{err: err, line: v.writeLine}
+	}
+	return err
+}
+
+func (v *buildVerdict) decide(err error) error {
+	switch {
+	case v.failed:
+		return fmt.Errorf("output matched --fail-pattern: %q", v.failLine)
+	case v.w.failOnStderr && v.wroteErr:
+		return errors.New("build wrote to stderr (--fail-on-stderr)")
+	case v.w.successPattern == nil:
+		return err
+	case v.succeeded:
+		if err != nil {
+			log.Printf("Build exited with %v, but its output matched --success-pattern; treating it as a success", err)
+		}
+		return nil
+	case err != nil:
+		return err
+	default:
+		return errors.New("output never matched --success-pattern")
+	}
+}
+
+// errNoRestart reports a successful build that exited with
+// --no-restart-exit-code: the running app is left as it is.
+var errNoRestart = errors.New("build asked for no restart")
+
+// noRestart reports whether err is the build exiting with
+// --no-restart-exit-code.
+func (w *Watcher) noRestart(err error) bool {
+	var exitErr *exec.ExitError
+	return w.noRestartCode != 0 && errors.As(err, &exitErr) && exitErr.ExitCode() == w.noRestartCode
+}
+
+// runJudged runs a build command, deciding success by its exit code or, when
+// configured, by what it printed. It returns errNoRestart for a build that
+// succeeded with --no-restart-exit-code, and a *writeError for one that
+// failed to write a file.
+func (w *Watcher) runJudged(cmd *exec.Cmd) error {
+	v := &buildVerdict{w: w}
+	stdout := &lineCapture{dst: cmd.Stdout, strip: true, onLine: func(line string) { v.line(false, line) }}
+	stderr := &lineCapture{dst: cmd.Stderr, strip: true, onLine: func(line string) { v.line(true, line) }}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	err := w.runBuildProcess(cmd)
+	stdout.flush()
+	stderr.flush()
+	if w.noRestart(err) {
+		if err := v.judge(nil); err != nil {
+			return err
+		}
+		return errNoRestart
+	}
+	return v.judge(err)
+}
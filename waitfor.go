@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// waitPollInterval is how often an unmet --wait-for condition is retried.
+const waitPollInterval = 200 * time.Millisecond
+
+// waitCondition is one --wait-for gate that must hold before the app starts.
+type waitCondition struct {
+	kind   string // "tcp", "file" or "cmd"
+	target string
+}
+
+func (c waitCondition) String() string {
+	return c.kind + ":" + c.target
+}
+
+func parseWaitCondition(s string) (waitCondition, error) {
+	kind, target, ok := strings.Cut(s, ":")
+	if !ok || strings.TrimSpace(target) == "" {
+		return waitCondition{}, fmt.Errorf("invalid --wait-for %q, want tcp:host:port, file:path or cmd:command", s)
+	}
+	switch kind {
+	case "tcp":
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return waitCondition{}, fmt.Errorf("invalid --wait-for %q: %v", s, err)
+		}
+	case "file", "cmd":
+	default:
+		return waitCondition{}, fmt.Errorf("invalid --wait-for %q: unknown kind %q (want tcp, file or cmd)", s, kind)
+	}
+	return waitCondition{kind: kind, target: target}, nil
+}
+
+// met checks the condition once. A cmd condition still running when ctx is
+// done is killed, along with everything it started.
+func (c waitCondition) met(ctx context.Context) bool {
+	switch c.kind {
+	case "tcp":
+		conn, err := net.DialTimeout("tcp", c.target, time.Second)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	case "file":
+		_, err := os.Stat(c.target)
+		return err == nil
+	case "cmd":
+		return groupCommand(ctx, c.target).Run() == nil
+	}
+	return false
+}
+
+// waitBeforeStart gates starting the app: it sleeps for --pre-run-delay, then
+// waits for each --wait-for condition in turn, polling until it holds or
+// --wait-timeout runs out.
+func (w *Watcher) waitBeforeStart() error {
+	if w.preRunDelay > 0 {
+		log.Printf("Waiting %s before starting app (--pre-run-delay)", w.preRunDelay)
+		if !w.sleep(w.preRunDelay) {
+			return ErrStopped
+		}
+	}
+	for _, c := range w.waitFor {
+		ctx, cancel := w.stopContext(w.waitTimeout)
+		err := w.awaitCondition(ctx, c)
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// awaitCondition polls c until it holds or ctx, bounded by --wait-timeout, is
+// done.
+func (w *Watcher) awaitCondition(ctx context.Context, c waitCondition) error {
+	for logged := false; ; logged = true {
+		if c.met(ctx) {
+			return nil
+		}
+		if w.stopped() {
+			return ErrStopped
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("timed out after %s waiting for %s", w.waitTimeout, c)
+		}
+		if !logged {
+			log.Printf("Waiting for %s before starting app...", c)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(waitPollInterval):
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWaitForHangingCmd(t *testing.T) {
+	w := NewWatcher([]string{t.TempDir()}, time.Second, "", "", "", "", nil, nil)
+	w.waitFor = []waitCondition{{kind: "cmd", target: "sleep 30; true"}}
+	w.waitTimeout = 200 * time.Millisecond
+
+	start := time.Now()
+	if err := w.waitBeforeStart(); err == nil {
+		t.Fatal("waitBeforeStart succeeded")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("waitBeforeStart took %s with a --wait-timeout of 200ms", d)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		w.Stop()
+	}()
+	w.waitTimeout = time.Minute
+	start = time.Now()
+	if err := w.waitBeforeStart(); err != ErrStopped {
+		t.Fatalf("waitBeforeStart returned %v, want ErrStopped", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("waitBeforeStart took %s to notice Stop", d)
+	}
+}
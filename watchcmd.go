@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultWatchCmdTimeout is how long a --watch-cmd run may take by default.
+const defaultWatchCmdTimeout = 10 * time.Second
+
+// watchSet is the file list --watch-cmd last produced.
+type watchSet struct {
+	files   []string
+	at      time.Time
+	stale   bool // a dep command ran, so the set may have changed
+	failing bool // the last refresh failed; logged once until it works again
+}
+
+// runWatchCmd runs --watch-cmd and returns the files it printed, one per
+// line, relative to the working directory or absolute, sorted and without
+// duplicates. A run past --watch-cmd-timeout, or still going when the
+// watcher stops, is killed.
+func (w *Watcher) runWatchCmd() ([]string, error) {
+	ctx, cancel := w.stopContext(w.watchCmdTimeout)
+	defer cancel()
+	cmd := groupCommand(ctx, w.watchCmd)
+	cmd.Stderr = w.outputWriter("build", os.Stderr)
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("timed out after %s (--watch-cmd-timeout)", w.watchCmdTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" {
+			seen[filepath.Clean(line)] = true
+		}
+	}
+	return sortedKeys(seen), nil
+}
+
+// watchedFiles returns the current --watch-cmd set, rerunning the command
+// every --watch-cmd-interval and after a dep command ran. If it fails, the
+// last set it produced stays in use.
+func (w *Watcher) watchedFiles() ([]string, error) {
+	s := &w.watchSet
+	if s.files != nil && !s.stale && time.Since(s.at) < w.watchCmdInterval {
+		return s.files, nil
+	}
+	files, err := w.runWatchCmd()
+	if err != nil {
+		if s.files == nil {
+			return nil, fmt.Errorf("--watch-cmd: %w", err)
+		}
+		if !s.failing {
+			log.Printf("--watch-cmd failed, keeping the last %d files: %v", len(s.files), err)
+			s.failing = true
+		}
+		// Try again next interval rather than on every scan
+		s.at, s.stale = time.Now(), false
+		return s.files, nil
+	}
+	if s.files != nil && len(files) != len(s.files) {
+		w.debugf("--watch-cmd now lists %d files (was %d)", len(files), len(s.files))
+	}
+	*s = watchSet{files: files, at: time.Now()}
+	return files, nil
+}
+
+// scanWatchSet hashes exactly the files --watch-cmd lists. Include and
+// exclude rules don't apply; a listed file that doesn't exist is left out,
+// so it being created counts as a change.
+func (w *Watcher) scanWatchSet(h hash.Hash, scan *scanResult) error {
+	files, err := w.watchedFiles()
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		if w.ownFile(name) {
+			continue
+		}
+		info, err := os.Lstat(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			w.walkErrors.logf("Error accessing %s: %v", name, err)
+			continue
+		}
+		if info.IsDir() {
+			continue
+		}
+		w.addFile(h, scan, os.DirFS(filepath.Dir(name)), filepath.Base(name), name, info)
+	}
+	return nil
+}
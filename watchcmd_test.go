@@ -0,0 +1,20 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRunWatchCmdTimeout(t *testing.T) {
+	w := NewWatcher([]string{t.TempDir()}, time.Second, "", "", "", "", nil, nil)
+	w.watchCmd = "echo main.go; sleep 30"
+	w.watchCmdTimeout = 200 * time.Millisecond
+
+	start := time.Now()
+	if _, err := w.runWatchCmd(); err == nil {
+		t.Fatal("runWatchCmd succeeded")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("runWatchCmd took %s with a --watch-cmd-timeout of 200ms", d)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// writeFailure matches the lines compilers, linkers and cp print when they
+// can't write the build's output.
+var writeFailure = regexp.MustCompile(`(?i)permission denied|operation not permitted|text file busy|read-only file system|access is denied|being used by another process`)
+
+// writePath pulls the path out of lines such as "open ./myapp: permission
+// denied" or "cp: cannot create regular file 'bin/app': Text file busy".
+var writePath = regexp.MustCompile(`(?:open|create|remove|rename|write|unlink)\s+(?:(?:regular|output) file\s+)?['"‘]?([^\s'"’:]+)['"’]?:`)
+
+// writeError is a failed build whose output shows it couldn't write a file.
+type writeError struct {
+	err  error
+	line string // the first output line that said so
+}
+
+func (e *writeError) Error() string { return e.err.Error() }
+func (e *writeError) Unwrap() error { return e.err }
+
+// hint says what likely went wrong and what to do about it.
+func (e *writeError) hint() string {
+	target := "the build output"
+	if m := writePath.FindStringSubmatch(e.line); m != nil {
+		target = m[1]
+	}
+	line := strings.ToLower(e.line)
+	switch {
+	case strings.Contains(line, "read-only file system"):
+		return "cannot write " + target + ": the file system is read-only; build into a writable directory"
+	case strings.Contains(line, "text file busy"), strings.Contains(line, "being used by another process"), strings.Contains(line, "access is denied"):
+		return "cannot write " + target + ": is the app still running from it? Build to a temporary name and move it into place (e.g. go build -o app.new && mv app.new app), or use --shadow-build"
+	default:
+		return "cannot write " + target + ": check the permissions and owner of it and its directory (left behind by a build run as another user?)"
+	}
+}
+
+// logBuildHint follows a build failure with advice when its output shows
+// what went wrong.
+func logBuildHint(err error) {
+	var we *writeError
+	if errors.As(err, &we) {
+		logHint(we.hint())
+	}
+}
+
+// logAppExitHint explains the exit codes the shell uses when it can't start
+// the run command at all.
+func logAppExitHint(err error) {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return
+	}
+	switch exitErr.ExitCode() {
+	case 126:
+		logHint("exit status 126: the shell couldn't execute the run target; check it is executable and built for this platform")
+	case 127:
+		logHint("exit status 127: the run command wasn't found; check the build's output path and --run")
+	}
+}
+
+func logHint(hint string) {
+	log.Println("Hint:", hint)
+}
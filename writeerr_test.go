@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWriteErrorHint(t *testing.T) {
+	for _, tt := range []struct {
+		line   string
+		target string // "" when writePath finds none
+		hint   string // the part of the hint that tells the cases apart
+	}{
+		{"open ./myapp: permission denied", "./myapp", "check the permissions"},
+		{"cp: cannot create regular file 'bin/app': Text file busy", "bin/app", "is the app still running from it?"},
+		{"go: creating work dir: mkdir /tmp/go-build123: read-only file system", "", "the file system is read-only"},
+		{"open bin/app: read-only file system", "bin/app", "the file system is read-only"},
+		{"The process cannot access the file because it is being used by another process.", "", "is the app still running from it?"},
+		{"open app.exe: Access is denied.", "app.exe", "is the app still running from it?"},
+	} {
+		if !writeFailure.MatchString(tt.line) {
+			t.Errorf("writeFailure doesn't match %q", tt.line)
+		}
+		var target string
+		if m := writePath.FindStringSubmatch(tt.line); m != nil {
+			target = m[1]
+		}
+		if target != tt.target {
+			t.Errorf("writePath in %q: got %q, want %q", tt.line, target, tt.target)
+		}
+
+		hint := (&writeError{err: errors.New("exit status 1"), line: tt.line}).hint()
+		want := "cannot write " + tt.target + ":"
+		if tt.target == "" {
+			want = "cannot write the build output:"
+		}
+		if !strings.HasPrefix(hint, want) || !strings.Contains(hint, tt.hint) {
+			t.Errorf("hint for %q = %q, want %q ... %q", tt.line, hint, want, tt.hint)
+		}
+	}
+}